@@ -0,0 +1,105 @@
+package envtmpl
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// FuncDoc documents a template function.
+type FuncDoc struct {
+	Name string `json:"name"`
+	// Summary is the first sentence of Short.
+	Summary string `json:"summary"`
+	Short   string `json:"short"`
+	// Signature is the Go signature of the function, such as
+	// "hash(...string) (string, error)".
+	Signature string   `json:"signature"`
+	Params    []string `json:"params"`
+	Results   []string `json:"results"`
+	Variadic  bool     `json:"variadic"`
+	// Errors reports whether the function can fail, which aborts
+	// rendering.
+	Errors   bool          `json:"errors"`
+	Examples []FuncExample `json:"examples"`
+	// Error is set when the examples could not be rendered.
+	Error string `json:"error,omitempty"`
+}
+
+// FuncExample is an example template along with its output.
+type FuncExample struct {
+	Template string `json:"template"`
+	Output   string `json:"output"`
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Doc documents fn, registered under name, rendering its examples and
+// reflecting on its function value to describe its signature.
+func Doc(name string, fn Func) (FuncDoc, error) {
+	d := FuncDoc{
+		Name:     name,
+		Summary:  strings.SplitAfter(fn.ShortUsage(), ".")[0],
+		Short:    fn.ShortUsage(),
+		Params:   []string{},
+		Results:  []string{},
+		Examples: []FuncExample{},
+	}
+	t := template.New(name)
+	v := fn.F(t, ExampleRuntime())
+	if r, ok := fn.(FuncReleaser); ok {
+		r.Release(t)
+	}
+	if ft := reflect.TypeOf(v); ft != nil && ft.Kind() == reflect.Func {
+		d.Variadic = ft.IsVariadic()
+		for i := 0; i < ft.NumIn(); i++ {
+			p := ft.In(i).String()
+			if d.Variadic && i == ft.NumIn()-1 {
+				p = "..." + ft.In(i).Elem().String()
+			}
+			d.Params = append(d.Params, p)
+		}
+		for i := 0; i < ft.NumOut(); i++ {
+			d.Results = append(d.Results, ft.Out(i).String())
+			d.Errors = d.Errors || ft.Out(i) == errorType
+		}
+		d.Signature = name + "(" + strings.Join(d.Params, ", ") + ")"
+		switch len(d.Results) {
+		case 0:
+		case 1:
+			d.Signature += " " + d.Results[0]
+		default:
+			d.Signature += " (" + strings.Join(d.Results, ", ") + ")"
+		}
+	}
+	ex, err := Examples(name, fn)
+	if err != nil {
+		return d, err
+	}
+	for e, out := range ex {
+		d.Examples = append(d.Examples, FuncExample{Template: e, Output: out})
+	}
+	sort.Slice(d.Examples, func(i, j int) bool {
+		return d.Examples[i].Template < d.Examples[j].Template
+	})
+	return d, nil
+}
+
+// Docs documents every registered function, sorted by name. A function whose
+// examples fail to render is still documented, with the failure given by its
+// Error.
+func Docs() []FuncDoc {
+	var docs []FuncDoc
+	for name, fn := range funcMap {
+		d, err := Doc(name, fn)
+		if err != nil {
+			d.Error = err.Error()
+		}
+		docs = append(docs, d)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].Name < docs[j].Name
+	})
+	return docs
+}
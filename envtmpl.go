@@ -0,0 +1,155 @@
+// Package envtmpl renders text/template templates with a set of additional
+// template functions. It is the library behind the envtmpl command, which is
+// a thin wrapper around the Renderer type.
+//
+// Additional functions, along with their documentation and examples, can be
+// made available to every Renderer with Register.
+package envtmpl
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+var funcMap = make(FuncMap)
+
+// Register makes a template function available under name to every
+// Renderer created afterwards. It is intended to be called from init
+// functions and panics if fn is nil or name is already registered.
+func Register(name string, fn Func) {
+	if fn == nil {
+		panic("envtmpl: Register func is nil")
+	}
+	if _, dup := funcMap[name]; dup {
+		panic("envtmpl: Register called twice for func " + name)
+	}
+	funcMap[name] = fn
+}
+
+// Funcs returns a copy of the registered template functions.
+func Funcs() FuncMap {
+	m := make(FuncMap, len(funcMap))
+	for k, v := range funcMap {
+		m[k] = v
+	}
+	return m
+}
+
+// Examples renders each example of fn, registered under name, returning the
+// output keyed by the example template. Examples are rendered with the
+// ExampleRuntime so that their output is predictable.
+func Examples(name string, fn Func) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, e := range fn.Example(name) {
+		var b bytes.Buffer
+		r := New(name, WithRuntime(ExampleRuntime()))
+		if err := r.Parse(name, e); err != nil {
+			return nil, err
+		}
+		err := r.Render(&b, name, struct{ FOO string }{FOO: "foo"})
+		if err != nil {
+			return nil, err
+		}
+		out[e] = b.String()
+	}
+	return out, nil
+}
+
+// FuncMap maps template function names to their implementations.
+type FuncMap map[string]Func
+
+// TemplateFuncs returns the functions bound to t and rt, suitable for
+// passing to t.Funcs. Release should be called once t is no longer used.
+func (m FuncMap) TemplateFuncs(t *template.Template, rt *Runtime) template.FuncMap {
+	funcs := make(template.FuncMap)
+	for k, v := range m {
+		funcs[k] = v.F(t, rt)
+	}
+	return funcs
+}
+
+// Release releases any state held by the functions for t.
+func (m FuncMap) Release(t *template.Template) {
+	for _, v := range m {
+		if r, ok := v.(FuncReleaser); ok {
+			r.Release(t)
+		}
+	}
+}
+
+// Func is a documented template function.
+type Func interface {
+	// ShortUsage is a short description of the function. The first
+	// sentence is used as a summary.
+	ShortUsage() string
+	// Example returns example templates calling the function as name.
+	Example(name string) []string
+	// F returns the function value bound to the template t, using rt to
+	// access the outside world.
+	F(t *template.Template, rt *Runtime) interface{}
+}
+
+// FuncStruct is a Func whose value doesn't depend on the template it is
+// used in. Examples are fmt format strings given the function name.
+type FuncStruct struct {
+	Short    string
+	Examples []string
+	Fn       interface{}
+}
+
+func (s *FuncStruct) ShortUsage() string {
+	return s.Short
+}
+
+func (s *FuncStruct) Example(name string) []string {
+	e := make([]string, len(s.Examples))
+	for k, v := range s.Examples {
+		e[k] = fmt.Sprintf(v, name)
+	}
+	return e
+}
+
+func (s *FuncStruct) F(_ *template.Template, _ *Runtime) interface{} {
+	return s.Fn
+}
+
+// FuncReleaser is implemented by a Func that holds state for the template
+// it is bound to. Render binds functions to a fresh copy of the template set
+// each time, and calls Release once that render has finished.
+type FuncReleaser interface {
+	Release(t *template.Template)
+}
+
+// FuncFactoryStruct is a Func whose value is created for the template it
+// is used in and the Runtime, such as include or file. Examples are fmt
+// format strings given the function name. Cleanup, if set, is called to
+// release any state held for a template.
+type FuncFactoryStruct struct {
+	Short    string
+	Examples []string
+	Fn       func(*template.Template, *Runtime) interface{}
+	Cleanup  func(*template.Template)
+}
+
+func (s *FuncFactoryStruct) ShortUsage() string {
+	return s.Short
+}
+
+func (s *FuncFactoryStruct) Example(name string) []string {
+	e := make([]string, len(s.Examples))
+	for k, v := range s.Examples {
+		e[k] = fmt.Sprintf(v, name)
+	}
+	return e
+}
+
+func (s *FuncFactoryStruct) F(t *template.Template, rt *Runtime) interface{} {
+	return s.Fn(t, rt)
+}
+
+func (s *FuncFactoryStruct) Release(t *template.Template) {
+	if s.Cleanup != nil {
+		s.Cleanup(t)
+	}
+}
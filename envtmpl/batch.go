@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/williambailey/go-envtmpl"
+)
+
+// stringsFlag is a flag.Value that collects every occurrence of a flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// target is a template to render and where to send it. An empty output
+// means STDOUT. A non-zero perm is the file mode used when -mode isn't given.
+// When auto is set, the output given by the template's front matter is used
+// in place of STDOUT.
+type target struct {
+	name   string
+	output string
+	perm   os.FileMode
+	auto   bool
+}
+
+func parseTarget(s string) (target, error) {
+	o := strings.Index(s, ":")
+	if o < 0 && s != "" {
+		return target{name: s, auto: true}, nil
+	}
+	if o <= 0 || o == len(s)-1 {
+		return target{}, fmt.Errorf("expecting tmplName.tmpl[:path], got '%s'", s)
+	}
+	t := target{name: s[:o], output: s[o+1:]}
+	if t.output == "-" {
+		t.output = ""
+	}
+	return t, nil
+}
+
+func readManifest(file string) ([]target, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var targets []target
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		t, err := parseTarget(l)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", file, n, err)
+		}
+		targets = append(targets, t)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, errors.New(file + ": no targets")
+	}
+	return targets, nil
+}
+
+// render executes each target in turn. Every target is attempted even if an
+// earlier one fails. The exit code reflects the most significant failure,
+// execution errors taking priority over missing variables, then output
+// errors and then differences found with -diff.
+func (app *cli) render(r *envtmpl.Renderer, j *job, data interface{}) int {
+	var failedExec, failedMissing, failedOutput, differs int
+	execFailed := func(name string, err error) {
+		if app.executionError(r, j.tmplDir, name, err) == exitMissingVariable {
+			failedMissing++
+		} else {
+			failedExec++
+		}
+	}
+	for _, t := range j.targets {
+		fm := app.frontMatter[t.name]
+		t, tdata, err := app.prepareTarget(j, t, data)
+		if err != nil {
+			fmt.Fprintf(app.stderr, "Missing environment variable: %s\n", err)
+			failedMissing++
+			continue
+		}
+		if t.output == "" && (fm == nil || fm.Format == "") {
+			if err := r.Render(app.stdout, t.name, tdata); err != nil {
+				execFailed(t.name, err)
+			}
+			continue
+		}
+		var b bytes.Buffer
+		if err := r.Render(&b, t.name, tdata); err != nil {
+			execFailed(t.name, err)
+			continue
+		}
+		if err := fm.validateOutput(b.Bytes()); err != nil {
+			fmt.Fprintf(app.stderr, "Template execution: %s\n", err)
+			failedExec++
+			continue
+		}
+		if t.output == "" {
+			app.stdout.Write(b.Bytes())
+			continue
+		}
+		if j.diff {
+			old, err := ioutil.ReadFile(t.output)
+			if err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(app.stderr, "Output error: %s\n", err)
+				failedOutput++
+				continue
+			}
+			if d := unifiedDiff(t.output, t.output+" (rendered)", old, b.Bytes()); d != "" {
+				fmt.Fprint(app.stdout, d)
+				differs++
+			}
+			continue
+		}
+		if *app.flagWatch {
+			if old, err := ioutil.ReadFile(t.output); err == nil && bytes.Equal(old, b.Bytes()) {
+				continue
+			}
+		}
+		perm := j.perm
+		if perm == 0 && fm != nil {
+			perm = fm.perm
+		}
+		if perm == 0 {
+			perm = t.perm
+		}
+		err = writeFileAtomic(t.output, b.Bytes(), perm, *app.flagUid, *app.flagGid)
+		if err != nil {
+			fmt.Fprintf(app.stderr, "Output error: %s\n", err)
+			failedOutput++
+			continue
+		}
+		if *app.flagWatch {
+			fmt.Fprintf(app.stderr, "Wrote %s\n", t.output)
+		}
+	}
+	if j.batch && failedExec+failedMissing+failedOutput > 0 {
+		fmt.Fprintf(
+			app.stderr,
+			"%d of %d targets failed (%d execution, %d missing variable, %d output).\n",
+			failedExec+failedMissing+failedOutput,
+			len(j.targets),
+			failedExec,
+			failedMissing,
+			failedOutput,
+		)
+	}
+	switch {
+	case failedExec > 0:
+		return exitTemplateExecutionError
+	case failedMissing > 0:
+		return exitMissingVariable
+	case failedOutput > 0:
+		return exitOutputError
+	case differs > 0:
+		return exitDiff
+	}
+	return exitOk
+}
+
+// prepareTarget returns t with any output given by its front matter, along
+// with the data to render it with: data with the defaults of the front
+// matter applied and, with -context, .Env, .Args and .Meta added. An error
+// names the required variables that are missing.
+func (app *cli) prepareTarget(j *job, t target, data interface{}) (target, interface{}, error) {
+	fm := app.frontMatter[t.name]
+	tdata, err := fm.withDefaults(data)
+	if err != nil {
+		return t, nil, err
+	}
+	if fm != nil && t.auto && fm.Output != "" {
+		t.output = fm.Output
+	}
+	if *app.flagContext {
+		tdata = app.withContext(tdata, j, t, fm)
+	}
+	return t, tdata, nil
+}
+
+var missingKeyError = regexp.MustCompile(
+	`template: ([^:]+):(\d+):(\d+): executing "([^"]*)" at <([^>]*)>: map has no entry for key "([^"]*)"`,
+)
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"text/template/parse"
+)
+
+// checkProblem is a problem found in a template by -check. Column is zero
+// for parse errors, which only report a line.
+type checkProblem struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+var parseError = regexp.MustCompile(`^template: (.+?):(\d+): ((?s).*)$`)
+
+// checker walks parse trees looking for problems that would otherwise only
+// be found when rendering.
+type checker struct {
+	path     func(name string) string
+	defined  map[string]bool
+	funcs    map[string]reflect.Type
+	problems []checkProblem
+}
+
+// check parses each template file on its own, so that every file with a
+// parse error is reported, and then checks the templates that parsed.
+func (app *cli) check(tmplDir string) int {
+	files, err := app.templateFiles(tmplDir)
+	if err != nil {
+		return app.parseFailure(tmplDir, err)
+	}
+	c := &checker{
+		path:    func(name string) string { return app.templateFile(tmplDir, name) },
+		defined: make(map[string]bool),
+		funcs:   make(map[string]reflect.Type),
+	}
+	var trees []*parse.Tree
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			c.problems = append(c.problems, checkProblem{File: file, Message: err.Error()})
+			continue
+		}
+		r := app.renderer(tmplDir)
+		src, err := app.source(filepath.Base(file), file, b)
+		if err == nil {
+			err = r.ParseSources(src)
+		}
+		if err != nil {
+			c.parseError(file, err)
+			continue
+		}
+		funcs, release := r.FuncMap()
+		for k, v := range funcs {
+			c.funcs[k] = reflect.TypeOf(v)
+		}
+		release()
+		for _, t := range r.Template().Templates() {
+			if t.Tree != nil {
+				c.defined[t.Name()] = true
+				trees = append(trees, t.Tree)
+			}
+		}
+	}
+	for _, tree := range trees {
+		c.node(tree, tree.Root)
+	}
+	sort.SliceStable(c.problems, func(i, j int) bool {
+		a, b := c.problems[i], c.problems[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	if *app.flagFormat == "json" {
+		problems := c.problems
+		if problems == nil {
+			problems = []checkProblem{}
+		}
+		b, _ := json.MarshalIndent(problems, "", "  ")
+		fmt.Fprintf(app.stdout, "%s\n", b)
+	} else {
+		for _, p := range c.problems {
+			switch {
+			case p.Column > 0:
+				fmt.Fprintf(app.stdout, "%s:%d:%d: %s\n", p.File, p.Line, p.Column, p.Message)
+			case p.Line > 0:
+				fmt.Fprintf(app.stdout, "%s:%d: %s\n", p.File, p.Line, p.Message)
+			default:
+				fmt.Fprintf(app.stdout, "%s: %s\n", p.File, p.Message)
+			}
+		}
+	}
+	if len(c.problems) > 0 {
+		return exitTemplateParseError
+	}
+	return exitOk
+}
+
+func (c *checker) parseError(file string, err error) {
+	p := checkProblem{File: file, Message: err.Error()}
+	if m := parseError.FindStringSubmatch(err.Error()); m != nil {
+		p.File = c.path(m[1])
+		p.Line, _ = strconv.Atoi(m[2])
+		p.Message = m[3]
+	}
+	c.problems = append(c.problems, p)
+}
+
+func (c *checker) add(tree *parse.Tree, n parse.Node, format string, a ...interface{}) {
+	p := checkProblem{Message: fmt.Sprintf(format, a...)}
+	p.File, p.Line, p.Column = nodeLocation(c.path, tree, n)
+	c.problems = append(c.problems, p)
+}
+
+func (c *checker) node(tree *parse.Tree, n parse.Node) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, l := range n.Nodes {
+			c.node(tree, l)
+		}
+	case *parse.ActionNode:
+		c.node(tree, n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for i, cmd := range n.Cmds {
+			c.command(tree, cmd, i > 0)
+		}
+	case *parse.IfNode:
+		c.node(tree, n.Pipe)
+		c.node(tree, n.List)
+		c.node(tree, n.ElseList)
+	case *parse.WithNode:
+		c.node(tree, n.Pipe)
+		c.node(tree, n.List)
+		c.node(tree, n.ElseList)
+	case *parse.RangeNode:
+		c.node(tree, n.Pipe)
+		c.node(tree, n.List)
+		c.node(tree, n.ElseList)
+	case *parse.TemplateNode:
+		if !c.defined[n.Name] {
+			c.add(tree, n, "no such template %q", n.Name)
+		}
+		c.node(tree, n.Pipe)
+	case *parse.ChainNode:
+		c.node(tree, n.Node)
+	}
+}
+
+// command checks a command of a pipeline. piped reports whether the result
+// of the previous command is passed as the final argument.
+func (c *checker) command(tree *parse.Tree, cmd *parse.CommandNode, piped bool) {
+	if id, ok := cmd.Args[0].(*parse.IdentifierNode); ok {
+		if ft, ok := c.funcs[id.Ident]; ok {
+			n := len(cmd.Args) - 1
+			if piped {
+				n++
+			}
+			switch {
+			case ft.IsVariadic() && n < ft.NumIn()-1:
+				c.add(tree, cmd, "wrong number of args for %s: want at least %d got %d", id.Ident, ft.NumIn()-1, n)
+			case !ft.IsVariadic() && n != ft.NumIn():
+				c.add(tree, cmd, "wrong number of args for %s: want %d got %d", id.Ident, ft.NumIn(), n)
+			}
+		}
+		if len(cmd.Args) > 1 {
+			s, ok := cmd.Args[1].(*parse.StringNode)
+			switch {
+			case !ok:
+			case id.Ident == "include" && !c.defined[s.Text]:
+				c.add(tree, s, "no such template %q", s.Text)
+			case id.Ident == "regexReplace":
+				if _, err := regexp.Compile(s.Text); err != nil {
+					c.add(tree, s, "regexReplace: %s", err)
+				}
+			}
+		}
+	}
+	for _, a := range cmd.Args {
+		c.node(tree, a)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// renderMeta describes the render in progress, exposed to templates as .Meta
+// with -context.
+type renderMeta struct {
+	Template    string
+	TemplateDir string
+	Output      string
+	Time        time.Time
+	Hostname    string
+	Version     string
+	Delims      []string
+}
+
+// withContext returns data with .Env, .Args and .Meta added for the target
+// t. The variables stay available at the top level as well, with .Env
+// holding the same values.
+func (app *cli) withContext(data interface{}, j *job, t target, fm *frontMatter) interface{} {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return data
+	}
+	env := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if k != "Data" {
+			env[k] = v
+		}
+	}
+	out := make(map[string]interface{}, len(m)+3)
+	for k, v := range m {
+		out[k] = v
+	}
+	meta := &renderMeta{
+		Template:    t.name,
+		TemplateDir: j.tmplDir,
+		Output:      t.output,
+		Time:        app.runtime().Now(),
+		Version:     Version,
+		Delims:      []string{*app.flagDelimLeft, *app.flagDelimRight},
+	}
+	meta.Hostname, _ = os.Hostname()
+	if fm != nil && len(fm.Delims) == 2 {
+		meta.Delims = fm.Delims
+	}
+	args := j.args
+	if args == nil {
+		args = []string{}
+	}
+	out["Env"] = env
+	out["Args"] = args
+	out["Meta"] = meta
+	return out
+}
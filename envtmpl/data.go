@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var dataDecoders = map[string]func([]byte) (interface{}, error){
+	"json": func(b []byte) (interface{}, error) {
+		var v interface{}
+		err := json.Unmarshal(b, &v)
+		return v, err
+	},
+	"yaml": func(b []byte) (interface{}, error) {
+		var v interface{}
+		err := yaml.Unmarshal(b, &v)
+		return v, err
+	},
+	"toml": func(b []byte) (interface{}, error) {
+		var v map[string]interface{}
+		err := toml.Unmarshal(b, &v)
+		return v, err
+	},
+}
+
+var dataExtensions = map[string]string{
+	".json": "json",
+	".yaml": "yaml",
+	".yml":  "yaml",
+	".toml": "toml",
+}
+
+// parseDataFlag splits a -data flag of the form name[:format]=path.
+func parseDataFlag(s string) (name, format, file string, err error) {
+	o := strings.Index(s, "=")
+	if o <= 0 || o == len(s)-1 {
+		return "", "", "", fmt.Errorf("expecting name[:format]=path, got '%s'", s)
+	}
+	name, file = s[:o], s[o+1:]
+	if c := strings.Index(name, ":"); c >= 0 {
+		name, format = name[:c], strings.ToLower(name[c+1:])
+	}
+	if name == "" {
+		return "", "", "", fmt.Errorf("expecting name[:format]=path, got '%s'", s)
+	}
+	return name, format, file, nil
+}
+
+// loadDataFile decodes file. The format is detected from the file extension
+// when not given.
+func loadDataFile(file, format string) (interface{}, error) {
+	if format == "" {
+		format = dataExtensions[strings.ToLower(filepath.Ext(file))]
+		if format == "" {
+			return nil, fmt.Errorf("unable to detect format from extension '%s'", filepath.Ext(file))
+		}
+	}
+	decode, ok := dataDecoders[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format '%s'", format)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return decode(b)
+}
+
+// varOverride is a value given on the command line with -var, -var-json or
+// -var-file, in the order given.
+type varOverride struct {
+	flag string
+	arg  string
+}
+
+// varFlag is a flag.Value that adds every occurrence of a flag to a list
+// shared with other flags, so that the order across them is kept.
+type varFlag struct {
+	name string
+	vars *[]varOverride
+}
+
+func (f varFlag) String() string {
+	return ""
+}
+
+func (f varFlag) Set(v string) error {
+	*f.vars = append(*f.vars, varOverride{f.name, v})
+	return nil
+}
+
+// value returns the name and value of an override. -var values are strings,
+// -var-json values are decoded and -var-file values are the contents of the
+// file.
+func (o varOverride) value() (string, interface{}, error) {
+	i := strings.Index(o.arg, "=")
+	if i <= 0 {
+		return "", nil, fmt.Errorf("-%s: expecting KEY=value, got '%s'", o.flag, o.arg)
+	}
+	name, v := o.arg[:i], o.arg[i+1:]
+	switch o.flag {
+	case "var-json":
+		d, err := decodeJSONValue([]byte(v))
+		if err != nil {
+			return "", nil, fmt.Errorf("-%s %s: %s", o.flag, name, err)
+		}
+		return name, d, nil
+	case "var-file":
+		b, err := ioutil.ReadFile(v)
+		if err != nil {
+			return "", nil, fmt.Errorf("-%s %s: %s", o.flag, name, err)
+		}
+		return name, string(b), nil
+	}
+	return name, v, nil
+}
+
+// decodeJSONValue decodes b keeping whole numbers as int64, so that they
+// compare equal to integer constants with eq in templates.
+func decodeJSONValue(b []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if d.More() {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return jsonNumbers(v), nil
+}
+
+func jsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonNumbers(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = jsonNumbers(v[k])
+		}
+	}
+	return v
+}
+
+// fileVarPatterns returns the -file-var patterns.
+func (app *cli) fileVarPatterns() ([]envPattern, error) {
+	var patterns []envPattern
+	for _, s := range app.flagFileVars {
+		p, err := parseEnvPattern(s)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// fileVars sets each variable matching one of patterns to the contents of
+// the file named by the variable of the same name ending with suffix, less a
+// single trailing newline. Other variables ending with suffix are left alone.
+// Without patterns every variable ending with suffix is read, apart from
+// those that are already set or whose file can't be read, so that variables
+// such as COMPOSE_FILE are left alone.
+func fileVars(vars map[string]string, suffix string, patterns []envPattern) error {
+	var names []string
+	for k := range vars {
+		if !strings.HasSuffix(k, suffix) || len(k) == len(suffix) {
+			continue
+		}
+		if len(patterns) == 0 {
+			names = append(names, k)
+		}
+		for _, p := range patterns {
+			if p.match(strings.TrimSuffix(k, suffix)) {
+				names = append(names, k)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		name := strings.TrimSuffix(k, suffix)
+		if _, ok := vars[name]; ok {
+			if len(patterns) == 0 {
+				continue
+			}
+			return fmt.Errorf("both %s and %s are set", name, k)
+		}
+		b, err := ioutil.ReadFile(vars[k])
+		if err != nil {
+			if len(patterns) == 0 {
+				continue
+			}
+			return fmt.Errorf("%s: %s", k, err)
+		}
+		if bytes.HasSuffix(b, []byte("\n")) {
+			b = bytes.TrimSuffix(b[:len(b)-1], []byte("\r"))
+		}
+		vars[name] = string(b)
+	}
+	return nil
+}
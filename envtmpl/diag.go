@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"unicode/utf8"
+
+	"github.com/williambailey/go-envtmpl"
+)
+
+// errorFormats are the values accepted by -error-format.
+var errorFormats = map[string]bool{"text": true, "pretty": true, "json": true}
+
+var execError = regexp.MustCompile(
+	`^template: ([^:]+):(\d+):(\d+): executing "([^"]*)" at <(.*?)>: ((?s).*)$`,
+)
+
+var includeName = regexp.MustCompile(`^include "([^"]*)"`)
+
+// diagnostic describes an error found in a template. Lines and columns are
+// 1-based.
+type diagnostic struct {
+	Kind    string      `json:"kind"`
+	File    string      `json:"file,omitempty"`
+	Line    int         `json:"line,omitempty"`
+	Column  int         `json:"column,omitempty"`
+	Message string      `json:"message"`
+	Source  string      `json:"source,omitempty"`
+	Stack   []diagFrame `json:"stack,omitempty"`
+	// text is the single line summary used by -error-format text.
+	text string
+}
+
+// diagFrame is a template or include call that led to an error, innermost
+// first.
+type diagFrame struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Call   string `json:"call"`
+}
+
+// parseFailure reports a template parse error.
+func (app *cli) parseFailure(tmplDir string, err error) int {
+	d := &diagnostic{
+		Kind:    "parse",
+		Message: err.Error(),
+		text:    fmt.Sprintf("Template parse error: %s", err),
+	}
+	if m := parseError.FindStringSubmatch(err.Error()); m != nil {
+		d.File = app.templateFile(tmplDir, m[1])
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Message = m[3]
+		d.Source = app.sourceLine(d.File, d.Line)
+	}
+	app.report(d)
+	return exitTemplateParseError
+}
+
+// executionError reports an error from rendering the template name and
+// returns the exit code it maps to. Errors from within include calls are
+// unwrapped to find where they happened, and template calls are traced
+// statically to build the call stack.
+func (app *cli) executionError(r *envtmpl.Renderer, tmplDir, name string, err error) int {
+	d := &diagnostic{
+		Kind:    "execution",
+		Message: err.Error(),
+		text:    fmt.Sprintf("Template execution: %s", err),
+	}
+	code := exitTemplateExecutionError
+	var missing string
+	if m := missingKeyError.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[2])
+		col, _ := strconv.Atoi(m[3])
+		if rootLookup(r.Template(), name, m[1], m[6], line, col+1) {
+			missing = m[6]
+			d.Kind = "missing-variable"
+			d.text = fmt.Sprintf(
+				"Missing environment variable: %s (%s:%s:%s)",
+				m[6],
+				app.templateFile(tmplDir, m[1]),
+				m[2],
+				m[3],
+			)
+			code = exitMissingVariable
+		} else {
+			d.Kind = "missing-key"
+			d.text = fmt.Sprintf(
+				"Template execution: missing key %s in <%s> (%s:%s:%s)",
+				m[6],
+				m[5],
+				app.templateFile(tmplDir, m[1]),
+				m[2],
+				m[3],
+			)
+		}
+	}
+
+	msg := err.Error()
+	var frames [][]string
+	for m := execError.FindStringSubmatch(msg); m != nil; m = execError.FindStringSubmatch(msg) {
+		frames = append(frames, m)
+		msg = m[6]
+		if !strings.HasPrefix(msg, "error calling include: ") {
+			break
+		}
+		msg = strings.TrimPrefix(msg, "error calling include: ")
+	}
+	if len(frames) == 0 {
+		app.report(d)
+		return code
+	}
+	inner := frames[len(frames)-1]
+	d.File = app.templateFile(tmplDir, inner[1])
+	d.Line, _ = strconv.Atoi(inner[2])
+	d.Column, _ = strconv.Atoi(inner[3])
+	d.Column++
+	d.Message = msg
+	if missing != "" {
+		d.Message = fmt.Sprintf("missing environment variable %s", missing)
+	}
+	d.Source = app.sourceLine(d.File, d.Line)
+
+	// Each frame is executing a template that was reached from name, or
+	// from the template named by the include call of the frame before it.
+	for i := len(frames) - 1; i >= 0; i-- {
+		from := name
+		if i > 0 {
+			from = ""
+			if m := includeName.FindStringSubmatch(frames[i-1][5]); m != nil {
+				from = m[1]
+			}
+		}
+		if from != "" {
+			d.Stack = append(d.Stack, templateCalls(r.Template(), func(name string) string {
+				return app.templateFile(tmplDir, name)
+			}, from, frames[i][4])...)
+		}
+		if i > 0 {
+			f := frames[i-1]
+			line, _ := strconv.Atoi(f[2])
+			col, _ := strconv.Atoi(f[3])
+			d.Stack = append(d.Stack, diagFrame{
+				File:   app.templateFile(tmplDir, f[1]),
+				Line:   line,
+				Column: col + 1,
+				Call:   f[5],
+			})
+		}
+	}
+	app.report(d)
+	return code
+}
+
+// templateFile returns the path of the template file name, which may have
+// come from tmplDir or an -I directory.
+func (app *cli) templateFile(tmplDir, name string) string {
+	if f, ok := app.tmplFiles[name]; ok {
+		return f
+	}
+	if tmplDir == "-" {
+		return name
+	}
+	return filepath.Join(tmplDir, name)
+}
+
+// sourceLine returns the given line of a template file, or an empty string
+// if it can't be read.
+func (app *cli) sourceLine(file string, line int) string {
+	var b []byte
+	if file == "stdin" && app.stdinText != nil {
+		b = app.stdinText
+	} else if b, _ = ioutil.ReadFile(file); b == nil {
+		return ""
+	}
+	lines := bytes.Split(b, []byte("\n"))
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimRight(string(lines[line-1]), "\r")
+}
+
+// rootLookup reports whether a lookup of key that failed at file, line and
+// the 1-based col while rendering the template name is a lookup on the root
+// data map, which holds the environment variables. The templates are scanned
+// as they are for -vars, following template and include calls, so that .KEY
+// only counts where dot is still the root data.
+func rootLookup(tmpl *template.Template, name, file, key string, line, col int) bool {
+	s := &varScanner{
+		tmpl:    tmpl,
+		path:    func(s string) string { return s },
+		visited: make(map[string]bool),
+	}
+	s.template(name, true, true)
+	for _, r := range s.refs {
+		if r.Name == key && r.File == file && r.Line == line && r.Column == col {
+			return true
+		}
+	}
+	return false
+}
+
+// templateCalls returns the shortest chain of template actions leading from
+// the template from to the template to, innermost first.
+func templateCalls(tmpl *template.Template, path func(string) string, from, to string) []diagFrame {
+	type call struct {
+		parent string
+		frame  diagFrame
+	}
+	calls := map[string]call{from: {}}
+	queue := []string{from}
+	for len(queue) > 0 && queue[0] != to {
+		name := queue[0]
+		queue = queue[1:]
+		t := tmpl.Lookup(name)
+		if t == nil || t.Tree == nil {
+			continue
+		}
+		tree := t.Tree
+		templateNodes(tree.Root, func(n *parse.TemplateNode) {
+			if _, ok := calls[n.Name]; ok {
+				return
+			}
+			file, line, col := nodeLocation(path, tree, n)
+			calls[n.Name] = call{name, diagFrame{file, line, col, n.String()}}
+			queue = append(queue, n.Name)
+		})
+	}
+	if _, ok := calls[to]; !ok {
+		return nil
+	}
+	var frames []diagFrame
+	for n := to; n != from; n = calls[n].parent {
+		frames = append(frames, calls[n].frame)
+	}
+	return frames
+}
+
+// templateNodes calls fn for each template action in n.
+func templateNodes(n parse.Node, fn func(*parse.TemplateNode)) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			templateNodes(c, fn)
+		}
+	case *parse.IfNode:
+		templateNodes(n.List, fn)
+		templateNodes(n.ElseList, fn)
+	case *parse.WithNode:
+		templateNodes(n.List, fn)
+		templateNodes(n.ElseList, fn)
+	case *parse.RangeNode:
+		templateNodes(n.List, fn)
+		templateNodes(n.ElseList, fn)
+	case *parse.TemplateNode:
+		fn(n)
+	}
+}
+
+// report writes d to STDERR in the format given by -error-format.
+func (app *cli) report(d *diagnostic) {
+	switch *app.flagErrorFormat {
+	case "json":
+		b, _ := json.Marshal(d)
+		fmt.Fprintf(app.stderr, "%s\n", b)
+		return
+	case "pretty":
+	default:
+		fmt.Fprintln(app.stderr, d.text)
+		return
+	}
+	color := func(code, s string) string {
+		if !app.color() {
+			return s
+		}
+		return "\x1b[" + code + "m" + s + "\x1b[0m"
+	}
+	fmt.Fprintln(app.stderr, color("1;31", d.text))
+	if d.File == "" {
+		return
+	}
+	loc := fmt.Sprintf("%s:%d", d.File, d.Line)
+	if d.Column > 0 {
+		loc += fmt.Sprintf(":%d", d.Column)
+	}
+	fmt.Fprintf(app.stderr, "  --> %s\n", color("36", loc))
+	if d.Source != "" {
+		n := strconv.Itoa(d.Line)
+		pad := strings.Repeat(" ", len(n))
+		fmt.Fprintf(app.stderr, "  %s | %s\n", n, d.Source)
+		if d.Column > 0 {
+			fmt.Fprintf(app.stderr, "  %s | %s%s\n", pad, caretIndent(d.Source, d.Column-1), color("1;32", "^"))
+		}
+	}
+	for _, f := range d.Stack {
+		fmt.Fprintf(
+			app.stderr,
+			"  called from %s %s\n",
+			color("36", fmt.Sprintf("%s:%d:%d", f.File, f.Line, f.Column)),
+			f.Call,
+		)
+	}
+}
+
+// caretIndent returns the whitespace that lines a caret up under the byte
+// offset col of line, keeping tabs so that it lines up however they are
+// displayed.
+func caretIndent(line string, col int) string {
+	if col > len(line) {
+		col = len(line)
+	}
+	var b strings.Builder
+	for _, r := range line[:col] {
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else if r != utf8.RuneError {
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+// color reports whether pretty errors should be colored, which they are
+// when STDERR is a terminal and NO_COLOR isn't set.
+func (app *cli) color() bool {
+	if _, ok := app.lookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	f, ok := app.stderr.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
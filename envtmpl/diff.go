@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// diffOp is a line of a diff. Kind is ' ' for an unchanged line, '-' for a
+// line only in the old text and '+' for a line only in the new text.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// splitLines splits b after each newline. The last line has no newline when
+// b doesn't end with one.
+func splitLines(b []byte) []string {
+	var lines []string
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			lines = append(lines, string(b))
+			break
+		}
+		lines = append(lines, string(b[:i+1]))
+		b = b[i+1:]
+	}
+	return lines
+}
+
+// diffLines returns the edits turning a into b. It uses Myers' algorithm,
+// splitting at the middle of the edit path each time, so that memory use is
+// linear in the number of lines. As with GNU diff, ranges that differ by more
+// than diffCost edits are split where the search got furthest instead, so
+// that time stays reasonable for large, very different files at the cost of
+// a diff that may not be minimal.
+func diffLines(a, b []string) []diffOp {
+	ids := make(map[string]int)
+	id := func(lines []string) []int {
+		s := make([]int, len(lines))
+		for i, l := range lines {
+			n, ok := ids[l]
+			if !ok {
+				n = len(ids)
+				ids[l] = n
+			}
+			s[i] = n
+		}
+		return s
+	}
+	d := &differ{a: a, b: b, ai: id(a), bi: id(b)}
+	d.diff(0, len(a), 0, len(b))
+	return d.ops
+}
+
+// differ holds the lines being diffed, along with the same lines as ids so
+// that they are cheap to compare.
+type differ struct {
+	a, b   []string
+	ai, bi []int
+	ops    []diffOp
+}
+
+// diff appends the edits turning a[a0:a1] into b[b0:b1].
+func (d *differ) diff(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && d.ai[a0] == d.bi[b0] {
+		d.ops = append(d.ops, diffOp{' ', d.a[a0]})
+		a0++
+		b0++
+	}
+	n := 0
+	for a1-n > a0 && b1-n > b0 && d.ai[a1-n-1] == d.bi[b1-n-1] {
+		n++
+	}
+	a1, b1 = a1-n, b1-n
+	switch {
+	case a0 == a1:
+		for _, l := range d.b[b0:b1] {
+			d.ops = append(d.ops, diffOp{'+', l})
+		}
+	case b0 == b1:
+		for _, l := range d.a[a0:a1] {
+			d.ops = append(d.ops, diffOp{'-', l})
+		}
+	default:
+		x, y := d.middle(a0, a1, b0, b1)
+		d.diff(a0, x, b0, y)
+		d.diff(x, a1, y, b1)
+	}
+	for _, l := range d.a[a1 : a1+n] {
+		d.ops = append(d.ops, diffOp{' ', l})
+	}
+}
+
+// diffCost returns the number of edits after which middle gives up looking
+// for a shortest edit path between ranges with lines lines in total. Like GNU
+// diff's, it grows with the square root of lines.
+func diffCost(lines int) int {
+	c := 1
+	for ; lines != 0; lines >>= 2 {
+		c <<= 1
+	}
+	if c < 256 {
+		c = 256
+	}
+	return c
+}
+
+// middle returns a point on a shortest edit path from a[a0:a1] to b[b0:b1],
+// found by searching forwards from the start and backwards from the end
+// until the two searches meet. The ranges are not empty and differ in their
+// first and last lines, so the point is neither the start nor the end. When
+// the searches take more than diffCost edits, it returns the point either
+// search got furthest to instead, or the end of a and the start of b, which
+// replaces the whole range.
+func (d *differ) middle(a0, a1, b0, b1 int) (int, int) {
+	a, b := d.ai[a0:a1], d.bi[b0:b1]
+	n, m := len(a), len(b)
+	half := (n + m + 1) / 2
+	cost := diffCost(n + m)
+	// vf[half+k] is the furthest x reached on diagonal k = x - y searching
+	// forwards, and vb[half+k] the furthest reached searching backwards, with
+	// x and y counted from the end.
+	vf := make([]int, 2*half+2)
+	vb := make([]int, 2*half+2)
+	for i := range vf {
+		vf[i], vb[i] = -1, -1
+	}
+	vf[half+1], vb[half+1] = 0, 0
+	delta := n - m
+	odd := delta%2 != 0
+	// Diagonals that have run off the edge of the grid are skipped.
+	var fStart, fEnd, bStart, bEnd int
+	for e := 0; e < half; e++ {
+		for k := -e + fStart; k <= e-fEnd; k += 2 {
+			i := half + k
+			var x int
+			if k == -e || (k != e && vf[i-1] < vf[i+1]) {
+				x = vf[i+1]
+			} else {
+				x = vf[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			vf[i] = x
+			switch {
+			case x > n:
+				fEnd += 2
+			case y > m:
+				fStart += 2
+			case odd:
+				if j := half + delta - k; j >= 0 && j < len(vb) && vb[j] != -1 && x >= n-vb[j] {
+					return a0 + x, b0 + y
+				}
+			}
+		}
+		for k := -e + bStart; k <= e-bEnd; k += 2 {
+			i := half + k
+			var x int
+			if k == -e || (k != e && vb[i-1] < vb[i+1]) {
+				x = vb[i+1]
+			} else {
+				x = vb[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			vb[i] = x
+			switch {
+			case x > n:
+				bEnd += 2
+			case y > m:
+				bStart += 2
+			case !odd:
+				if j := half + delta - k; j >= 0 && j < len(vf) && vf[j] != -1 && vf[j] >= n-x {
+					return a0 + vf[j], b0 + vf[j] - (j - half)
+				}
+			}
+		}
+		if e < cost {
+			continue
+		}
+		x, y, best := 0, 0, 0
+		for k := -e + fStart; k <= e-fEnd; k += 2 {
+			if fx, fy := vf[half+k], vf[half+k]-k; fx <= n && fy >= 0 && fy <= m && fx+fy > best {
+				x, y, best = fx, fy, fx+fy
+			}
+		}
+		for k := -e + bStart; k <= e-bEnd; k += 2 {
+			if bx, by := vb[half+k], vb[half+k]-k; bx <= n && by >= 0 && by <= m && bx+by > best {
+				x, y, best = n-bx, m-by, bx+by
+			}
+		}
+		if x+y > 0 && x+y < n+m {
+			return a0 + x, b0 + y
+		}
+		break
+	}
+	return a1, b0
+}
+
+// unifiedDiff returns a unified diff turning a, named aName, into b, named
+// bName. It returns an empty string when a and b are equal.
+func unifiedDiff(aName, bName string, a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	ops := diffLines(splitLines(a), splitLines(b))
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+	// aLine and bLine are the number of lines of a and b before ops[i].
+	aLine := make([]int, len(ops)+1)
+	bLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if op.kind != '+' {
+			aLine[i+1]++
+		}
+		if op.kind != '-' {
+			bLine[i+1]++
+		}
+	}
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// Extend the hunk while the next change is close enough for their
+		// context to overlap.
+		end := i
+		for k := i; k < len(ops) && k <= end+2*diffContext; k++ {
+			if ops[k].kind != ' ' {
+				end = k
+			}
+		}
+		start, stop := i-diffContext, end+diffContext+1
+		if start < 0 {
+			start = 0
+		}
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+		writeHunkHeader(&out, aLine[start], aLine[stop]-aLine[start], bLine[start], bLine[stop]-bLine[start])
+		for _, op := range ops[start:stop] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			if len(op.line) == 0 || op.line[len(op.line)-1] != '\n' {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = stop
+	}
+	return out.String()
+}
+
+func writeHunkHeader(out *bytes.Buffer, aStart, aCount, bStart, bCount int) {
+	if aCount > 0 {
+		aStart++
+	}
+	if bCount > 0 {
+		bStart++
+	}
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+}
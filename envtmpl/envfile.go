@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode"
+)
+
+// envFileError is a syntax error found while parsing a .env file.
+type envFileError struct {
+	file string
+	line int
+	msg  string
+}
+
+func (e *envFileError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.file, e.line, e.msg)
+}
+
+// parseEnvFile parses dotenv syntax from r. The following is supported:
+//
+//	# comments, on their own or after unquoted values
+//	export KEY=value
+//	KEY=unquoted value
+//	KEY='single quoted, taken literally'
+//	KEY="double quoted with \n, \t, \", \\ and \$ escapes"
+//	KEY="quoted values
+//	may span lines"
+//	KEY=${OTHER} or $OTHER interpolation in unquoted and double quoted values
+//
+// Variables are interpolated using lookup, which is given the chance to see
+// each variable as soon as it has been parsed via set.
+func parseEnvFile(
+	file string,
+	r io.Reader,
+	lookup func(string) string,
+	set func(string, string),
+) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	p := &envFileParser{file: file, src: []rune(string(b)), line: 1, lookup: lookup}
+	for {
+		k, v, ok, err := p.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		set(k, v)
+	}
+}
+
+type envFileParser struct {
+	file   string
+	src    []rune
+	pos    int
+	line   int
+	lookup func(string) string
+}
+
+func (p *envFileParser) errorf(format string, a ...interface{}) error {
+	return &envFileError{file: p.file, line: p.line, msg: fmt.Sprintf(format, a...)}
+}
+
+func (p *envFileParser) peek() rune {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *envFileParser) advance() rune {
+	r := p.src[p.pos]
+	p.pos++
+	if r == '\n' {
+		p.line++
+	}
+	return r
+}
+
+func (p *envFileParser) skipBlank() {
+	for p.pos < len(p.src) && (p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\r') {
+		p.advance()
+	}
+}
+
+// skipLine consumes the rest of the line, which may only hold white space or
+// a comment.
+func (p *envFileParser) skipLine() error {
+	p.skipBlank()
+	switch p.peek() {
+	case 0:
+		return nil
+	case '\n':
+		p.advance()
+		return nil
+	case '#':
+		for p.pos < len(p.src) && p.advance() != '\n' {
+		}
+		return nil
+	}
+	return p.errorf("unexpected character %q", p.peek())
+}
+
+func isEnvKeyRune(r rune, first bool) bool {
+	if r == '_' || unicode.IsLetter(r) {
+		return true
+	}
+	return !first && unicode.IsDigit(r)
+}
+
+func (p *envFileParser) key() string {
+	start := p.pos
+	for p.pos < len(p.src) && isEnvKeyRune(p.peek(), p.pos == start) {
+		p.advance()
+	}
+	return string(p.src[start:p.pos])
+}
+
+func (p *envFileParser) next() (string, string, bool, error) {
+	for {
+		p.skipBlank()
+		switch p.peek() {
+		case 0:
+			return "", "", false, nil
+		case '\n', '#':
+			if err := p.skipLine(); err != nil {
+				return "", "", false, err
+			}
+			continue
+		}
+		break
+	}
+	k := p.key()
+	if k == "export" && (p.peek() == ' ' || p.peek() == '\t') {
+		p.skipBlank()
+		k = p.key()
+	}
+	if k == "" {
+		return "", "", false, p.errorf("expecting variable name, got %q", p.peek())
+	}
+	p.skipBlank()
+	if p.peek() != '=' {
+		return "", "", false, p.errorf("expecting '=' after %s", k)
+	}
+	p.advance()
+	p.skipBlank()
+	var v string
+	var err error
+	switch p.peek() {
+	case '\'':
+		v, err = p.singleQuoted()
+	case '"':
+		v, err = p.doubleQuoted()
+	default:
+		v, err = p.unquoted()
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+	if err := p.skipLine(); err != nil {
+		return "", "", false, err
+	}
+	return k, v, true, nil
+}
+
+func (p *envFileParser) singleQuoted() (string, error) {
+	line := p.line
+	p.advance()
+	start := p.pos
+	for p.pos < len(p.src) {
+		if p.advance() == '\'' {
+			return string(p.src[start : p.pos-1]), nil
+		}
+	}
+	p.line = line
+	return "", p.errorf("unterminated single quoted value")
+}
+
+func (p *envFileParser) doubleQuoted() (string, error) {
+	line := p.line
+	p.advance()
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		r := p.advance()
+		switch r {
+		case '"':
+			return b.String(), nil
+		case '$':
+			if err := p.interpolate(&b); err != nil {
+				return "", err
+			}
+		case '\\':
+			if p.pos >= len(p.src) {
+				continue
+			}
+			e := p.advance()
+			switch e {
+			case 'n':
+				b.WriteRune('\n')
+			case 'r':
+				b.WriteRune('\r')
+			case 't':
+				b.WriteRune('\t')
+			case '"', '\\', '$':
+				b.WriteRune(e)
+			case '\n':
+				// Line continuation.
+			default:
+				b.WriteRune('\\')
+				b.WriteRune(e)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	p.line = line
+	return "", p.errorf("unterminated double quoted value")
+}
+
+func (p *envFileParser) unquoted() (string, error) {
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		r := p.peek()
+		if r == '\n' || (r == '#' && (b.Len() == 0 || strings.HasSuffix(b.String(), " ") || strings.HasSuffix(b.String(), "\t"))) {
+			break
+		}
+		p.advance()
+		if r == '$' {
+			if err := p.interpolate(&b); err != nil {
+				return "", err
+			}
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return strings.TrimRightFunc(b.String(), unicode.IsSpace), nil
+}
+
+// interpolate expands ${VAR} or $VAR, the leading $ having already been
+// consumed. A $ that doesn't start a variable name is kept as is.
+func (p *envFileParser) interpolate(b *strings.Builder) error {
+	if p.peek() == '{' {
+		p.advance()
+		k := p.key()
+		if k == "" || p.peek() != '}' {
+			return p.errorf("invalid variable reference, expecting ${NAME}")
+		}
+		p.advance()
+		b.WriteString(p.lookup(k))
+		return nil
+	}
+	if !isEnvKeyRune(p.peek(), true) {
+		b.WriteRune('$')
+		return nil
+	}
+	b.WriteString(p.lookup(p.key()))
+	return nil
+}
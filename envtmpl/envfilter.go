@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// envPattern matches environment variable names. Patterns wrapped in
+// slashes, such as /^AWS_/, are regular expressions and anything else is a
+// glob, such as APP_*.
+type envPattern struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func parseEnvPattern(s string) (envPattern, error) {
+	p := envPattern{pattern: s}
+	if len(s) >= 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		re, err := regexp.Compile(s[1 : len(s)-1])
+		if err != nil {
+			return p, fmt.Errorf("%s: %s", s, err)
+		}
+		p.re = re
+		return p, nil
+	}
+	if _, err := filepath.Match(s, ""); err != nil {
+		return p, fmt.Errorf("%s: %s", s, err)
+	}
+	return p, nil
+}
+
+func (p envPattern) match(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	ok, _ := filepath.Match(p.pattern, name)
+	return ok
+}
+
+// envFilter decides which environment variables templates can see.
+type envFilter struct {
+	clean bool
+	allow []envPattern
+	deny  []envPattern
+}
+
+// envFilter builds the filter given by -i, -allow and -deny.
+func (app *cli) envFilter() (*envFilter, error) {
+	f := &envFilter{clean: *app.flagClean}
+	for _, s := range app.flagAllow {
+		p, err := parseEnvPattern(s)
+		if err != nil {
+			return nil, err
+		}
+		f.allow = append(f.allow, p)
+	}
+	for _, s := range app.flagDeny {
+		p, err := parseEnvPattern(s)
+		if err != nil {
+			return nil, err
+		}
+		f.deny = append(f.deny, p)
+	}
+	return f, nil
+}
+
+// reason returns why name is filtered out, or an empty string if it isn't.
+// A denied name is always filtered out. Otherwise, when there are -allow
+// patterns, or -i is given, the name must match an -allow pattern.
+func (f *envFilter) reason(name string) string {
+	for _, p := range f.deny {
+		if p.match(name) {
+			return "denied by " + p.pattern
+		}
+	}
+	if len(f.allow) == 0 && !f.clean {
+		return ""
+	}
+	for _, p := range f.allow {
+		if p.match(name) {
+			return ""
+		}
+	}
+	if len(f.allow) == 0 {
+		return "-i"
+	}
+	return "not allowed"
+}
+
+// environ returns the environment variables that pass the filter. With -v
+// the names of those that don't are listed on STDERR.
+func (app *cli) environ() (map[string]string, error) {
+	f, err := app.envFilter()
+	if err != nil {
+		return nil, err
+	}
+	env := make(map[string]string)
+	filtered := make(map[string]string)
+	for _, s := range app.env {
+		o := strings.Index(s, "=")
+		if o <= 0 {
+			continue
+		}
+		k := s[:o]
+		if r := f.reason(k); r != "" {
+			filtered[k] = r
+			delete(env, k)
+			continue
+		}
+		delete(filtered, k)
+		env[k] = s[o+1:]
+	}
+	if *app.flagVerbose && len(filtered) > 0 {
+		names := make([]string, 0, len(filtered))
+		for k := range filtered {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			fmt.Fprintf(app.stderr, "Filtered environment variable: %s (%s)\n", k, filtered[k])
+		}
+	}
+	return env, nil
+}
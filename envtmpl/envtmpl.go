@@ -6,9 +6,14 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"text/template"
+	"syscall"
+	"time"
+
+	"github.com/williambailey/go-envtmpl"
 )
 
 const Name = "envtmpl"
@@ -18,21 +23,66 @@ const usageTemplate = `
 Usage:
   {{ .cmd }} tmplDir tmplName.tmpl
   {{ .cmd }} tmplDir/tmplName.tmpl
+  {{ .cmd }} -context tmplDir tmplName.tmpl [args...]
   {{ .cmd }} -
+  {{ .cmd }} -t tmplName.tmpl:path [-t ...] tmplDir
+  {{ .cmd }} -manifest file tmplDir
+  {{ .cmd }} -r srcDir destDir
+  {{ .cmd }} -check tmplDir
+  {{ .cmd }} -test [-update] tmplDir
+  {{ .cmd }} ... -- command [args...]
 
 Parse tmplDir/*.tmpl and renders tmplName.tmpl to
 STDOUT using environment variables. If a dash is
 provided then the template is read from STDIN.
+Targets given with -t or -manifest are all rendered
+from a single parse of tmplDir/*.tmpl. If a command
+is given after -- then it replaces {{ .cmd }} once
+everything has been rendered.
 
 Flags:
   -dl '{{"{{"}}' Left-hand action delimiter.
   -dr '{{"}}"}}' Right-hand action delimiter.
+  -o path Write to path instead of STDOUT.
+  -diff path Display the changes rendering would make to path.
+  -mode 0644 File mode used with -o.
+  -uid -1 Owner user id used with -o.
+  -gid -1 Owner group id used with -o.
+  -t tmplName.tmpl:path Render a target. Can be repeated.
+  -manifest file Read targets from a file.
+  -r Render every template below srcDir into destDir.
+  -pattern '*.tmpl' Template file glob. Can be repeated.
+  -I dir Also parse templates from dir. Can be repeated.
+  -front-matter Read settings from front matter in templates.
+  -strict Fail on missing environment variables.
+  -env-file path Load variables from a .env file. Can be repeated.
+  -i Start from an empty environment.
+  -allow PATTERN Only expose matching environment variables. Can be repeated.
+  -deny PATTERN Hide matching environment variables. Can be repeated.
+  -v List the environment variables hidden by -i, -allow and -deny.
+  -data name[:format]=path Expose a data file as .Data.name. Can be repeated.
+  -nest __ Build nested data from variable names split by a separator.
+  -file-suffix _FILE Read FOO from the file named by FOO_FILE.
+  -file-var PATTERN Only read matching variables with -file-suffix. Can be repeated.
+  -var KEY=value Set .KEY to a string. Can be repeated.
+  -var-json KEY=json Set .KEY to a JSON value. Can be repeated.
+  -var-file KEY=path Set .KEY to the contents of a file. Can be repeated.
+  -context Expose .Env, .Args and .Meta to templates.
+  -vars List the environment variables used instead of rendering.
+  -format text Output format for -h, -vars or -check.
+  -watch Re-render whenever templates or data files change.
+  -watch-interval 1s How often to check for changes.
+  -error-format text Error format: text, pretty or json.
+  -check Check tmplDir/*.tmpl for problems without rendering.
+  -test Check templates against tmplDir/*.golden fixtures.
+  -update Rewrite the output of failing fixtures with -test.
 
 Version:
   {{ .version }}
 
 Help:
   {{ .cmd }} -h
+  {{ .cmd }} -h funcName
 `
 
 const helpTemplate = `
@@ -52,18 +102,228 @@ environment variables.
 
 Read template from STDIN and render to STDOUT using environment variables.
 
+#### {{ .usageBatch }}
+
+Parse **tmplDir/*.tmpl** once and render each **tmplName.tmpl** to **path**
+using environment variables. A path of **-** renders to STDOUT. Failures are
+reported per target, followed by a summary.
+
+#### {{ .usageTree }}
+
+Parse every **.tmpl** file below **srcDir**, or those matching **-pattern**,
+naming each template by its path relative to **srcDir**, such as
+**nginx/sites/default.conf.tmpl**. Each template is rendered to the same path
+below **destDir** with its extension removed, apart from partials whose file names start with **_**.
+Other files are copied as they are. Directories are created as needed and file
+modes are kept, unless **-mode** is given.
+
+#### {{ .usageCommand }}
+
+Render as above and then replace {{ .cmd }} with **command**, so that it keeps
+the process id and receives signals directly. This makes {{ .cmd }} suitable as
+a container entrypoint. The command is not run if rendering fails.
+
+#### {{ .usageCheck }}
+
+Parse each of **tmplDir/*.tmpl** and report problems without rendering:
+
+* The first parse error of each file.
+* **template** and **include** calls naming a template that isn't defined.
+* Functions called with the wrong number of arguments.
+* Literal regular expressions given to **regexReplace** that don't compile.
+
+Each problem is listed with its file, line and column, and {{ .cmd }} exits with
+a template parse error if any are found.
+
+#### {{ .usageTest }}
+
+Render templates using the golden fixtures in **tmplDir/*.golden** and compare
+the output with the expected output held in each fixture. A unified diff is
+displayed for each mismatch. **foo.tmpl.golden** tests **foo.tmpl**, as does
+**foo.tmpl.name.golden**, so a template may have several fixtures. A fixture
+holds sections, each starting with a **-- name --** line:
+
+    Anything before the first section is a comment.
+    -- env --
+    WHAT=World
+    -- data --
+    hosts=hosts.yaml
+    -- output --
+    Hello World!
+
+The **env** section uses .env file syntax and the **data** section lists
+**name[:format]=path** data files, relative to the fixture, one per line. The
+template only sees the variables and data given in the fixture. The
+**output** section runs to the next section or the end of the file, so the
+presence of a trailing newline is checked too.
+
 ### Flags
 
 * **-dl '{{"{{"}}'** Left-hand action delimiter.
 * **-dr '{{"}}"}}'** Right-hand action delimiter.
+* **-o path** Write to path instead of STDOUT. The output is written to a
+  temporary file in the same directory and renamed over path once rendering
+  has succeeded, so an existing file is left untouched on error.
+* **-diff path** Render to memory and display a unified diff of the changes
+  that writing to path would make, exiting with 9 if there are any. Nothing is
+  written. A missing path is treated as empty.
+* **-mode 0644** File mode used with -o. Defaults to the mode of the existing
+  file, or 0644.
+* **-uid -1** Owner user id used with -o. -1 leaves it unchanged.
+* **-gid -1** Owner group id used with -o. -1 leaves it unchanged.
+* **-t tmplName.tmpl:path** Render tmplName.tmpl to path. Can be repeated.
+  Output is written as with -o. The path can be left out when the template
+  gives one in its front matter.
+* **-manifest file** Read targets from file, one **tmplName.tmpl:path** per
+  line. Blank lines and lines starting with **#** are ignored.
+* **-r** Render the templates below srcDir into destDir, see above.
+* **-pattern '*.tmpl'** Parse the files in tmplDir matching a glob instead of
+  **tmplDir/*.tmpl**. Can be repeated, for example **-pattern '*.tpl' -pattern
+  '*.gotmpl'**.
+* **-I dir** Also parse the files matching the patterns in dir, so that shared
+  partials can be used with **template** or **include**. Can be repeated.
+  Templates are named after their file's base name, and it is an error for
+  two files to share one. The -I directories are parsed before tmplDir, and a
+  later define or block action replaces an earlier one, so templates in
+  tmplDir can override the blocks of shared partials, but it is an error for
+  define actions in two -I directories to define the same template.
+* **-front-matter** Read settings from a front matter block at the top of each
+  template, see below.
+* **-strict** Fail when a template references an environment variable that is
+  not set, instead of silently rendering a placeholder. Missing keys in -data
+  files and other nested values fail as template execution errors.
+* **-env-file path** Load variables from a .env file. Can be repeated.
+  Comments, an **export** prefix, single and double quoting, escapes,
+  multi-line quoted values and **${VAR}** interpolation are supported.
+  Variables set in the environment take precedence over .env files, and later
+  files take precedence over earlier ones.
+* **-i** Start from an empty environment, so that templates only see
+  environment variables matching an -allow pattern, along with any -env-file,
+  -data or -var values.
+* **-allow PATTERN** Only expose environment variables matching PATTERN to
+  templates. Can be repeated. Patterns are globs, such as **APP_\***, or
+  regular expressions when wrapped in slashes, such as **/^APP_[A-Z]+$/**.
+* **-deny PATTERN** Hide environment variables matching PATTERN from
+  templates, even if they are allowed. Can be repeated. Filtering is applied
+  before .env files are read, so hidden variables can't be interpolated
+  either. A command given after **--** still receives the full environment.
+* **-v** List the environment variables hidden by -i, -allow and -deny on
+  STDERR.
+* **-data name[:format]=path** Decode a JSON, YAML or TOML file and expose it
+  to templates as **.Data.name**. Can be repeated. The format is detected from
+  the file extension (.json, .yaml, .yml, .toml) unless given explicitly, for
+  example **-data hosts:yaml=hosts.conf**. Environment variables are still
+  available as **.FOO**.
+* **-nest __** Build nested data from variable names containing a separator,
+  so that **APP__DB__HOST** is also available as **.APP.DB.HOST**. Numeric
+  segments build lists, so **APP__UPSTREAMS__0__URL** and
+  **APP__UPSTREAMS__1__URL** can be used with **range .APP.UPSTREAMS**. The
+  flat names are still available. It is an error for a name to be both a
+  value and the parent of other values, such as **APP__DB** and
+  **APP__DB__HOST**, or for list indexes to have gaps. Names with an empty
+  segment, such as **__CF_USER_TEXT_ENCODING**, are left flat.
+* **-file-suffix _FILE** Set each variable to the contents of the file named
+  by the variable of the same name with the suffix, so that
+  **DB_PASSWORD_FILE=/run/secrets/db** sets **DB_PASSWORD**. This follows the
+  convention used for Docker and Kubernetes secrets. A single trailing newline
+  is removed from the contents. Variables that are already set, or whose file
+  can't be read, such as **COMPOSE_FILE**, are left alone.
+* **-file-var PATTERN** Only read the variables matching a pattern with
+  -file-suffix, as a glob, such as **DB_\***, or a regular expression when
+  wrapped in slashes. Can be repeated. It is then an error for a matching
+  file to be unreadable, or for both **DB_PASSWORD** and **DB_PASSWORD_FILE**
+  to be set.
+* **-var KEY=value** Set **.KEY** to a string. Can be repeated. Values given
+  with -var, -var-json and -var-file take precedence over the environment,
+  which takes precedence over -env-file files. When a key is given more than
+  once the last one wins, whichever of the three flags set it.
+* **-var-json KEY=json** Set **.KEY** to a decoded JSON value, such as
+  **-var-json 'PORTS=[80,443]'**. Numbers, booleans, lists and objects keep
+  their types, so **{{"{{"}} if eq .REPLICAS 3 {{"}}"}}** and
+  **{{"{{"}} if .DEBUG {{"}}"}}** work without parsing strings. Whole numbers are
+  integers. Can be repeated.
+* **-var-file KEY=path** Set **.KEY** to the contents of a file, as they are.
+  Can be repeated.
+* **-context** Expose **.Env**, **.Args** and **.Meta** to templates, see
+  below.
+* **-vars** List the environment variables used by tmplName.tmpl, and by any
+  templates it calls with **template** or **include**, instead of rendering.
+  Each reference is listed with its file, line and column. Lookups that can't
+  be resolved statically, such as **index . $name**, are flagged as dynamic.
+* **-h [funcName]** Display this help. If a function name is given then only
+  the matching functions are displayed, including their parameter and return
+  types. Names are matched exactly, then by prefix, then fuzzily, so
+  **-h b64d** finds **base64Decode**.
+* **-format text** Output format. With -vars or -check this is **text** (the
+  default) or **json**. With -h this is **markdown** (the default), **text**, **json** or
+  **man**. Help in a format other than markdown is written to STDOUT and exits
+  with 0, so that it can be consumed by other tools.
+* **-watch** Keep running and re-render whenever tmplDir/*.tmpl, an -env-file
+  or a -data file changes. Output files are only rewritten when their contents
+  change, and errors are reported without exiting. Requires -o or -t.
+* **-watch-interval 1s** How often -watch checks for changes.
+* **-check** Check tmplDir/*.tmpl for problems instead of rendering.
+* **-error-format text** How errors are reported. **text** (the default) writes
+  a single line per error. **pretty** adds the file, line and column, the line
+  of the template with the error marked, and the **template** and **include**
+  calls that led there, in color when STDERR is a terminal and NO_COLOR isn't
+  set. **json** writes the same details as a JSON object per line, for CI
+  annotations.
+* **-test** Check the templates in tmplDir against their golden fixtures
+  instead of rendering.
+* **-update** With -test, rewrite the output section of each fixture that
+  doesn't match, or doesn't have one, with the rendered output.
+
+### Front matter
+
+With **-front-matter**, a template can declare its own settings in a YAML
+block fenced by **---** lines, or a TOML block fenced by **+++** lines, at the
+very top of its file. The block is removed before the template is parsed.
+
+    ---
+    output: /etc/app/app.json
+    mode: "0640"
+    delims: ["[[", "]]"]
+    format: json
+    required:
+      DB_HOST:
+        description: Database host name.
+      DB_PORT:
+        default: "5432"
+    ---
+    {"db": "[[ .DB_HOST ]]:[[ .DB_PORT ]]"}
+
+* **output** Where to write the template when no -o is given, or -t gives no
+  path. With -r a relative path is relative to destDir.
+* **mode** File mode used when writing, unless -mode is given.
+* **delims** Action delimiters used instead of -dl and -dr.
+* **required** Environment variables the template needs. A missing variable
+  is set to its **default**, or fails the template with its **description**.
+* **format** Check that the output is valid **json**, **yaml** or **toml**
+  before it is written.
+
+### Context
+
+With **-context**, templates are given the following alongside the variables,
+which are still available as **.FOO**:
+
+* **.Env** The variables, so that **.Env.FOO** is the same as **.FOO**.
+* **.Args** Any arguments given after **tmplDir tmplName.tmpl**, as a list.
+* **.Meta.Template** The name of the template being rendered.
+* **.Meta.TemplateDir** The template directory, or **-** for STDIN.
+* **.Meta.Output** The path being written, or empty for STDOUT.
+* **.Meta.Time** When rendering started.
+* **.Meta.Hostname** The host name.
+* **.Meta.Version** The version of {{ .cmd }}.
+* **.Meta.Delims** The left and right action delimiters in use.
+
+Variables named **Env**, **Args** or **Meta** are hidden by these, but are
+still available from **.Env**.
 
 ### Exit codes
 
-* 0 - OK.
-* 1 - Usage.
-* 2 - Template parse error.
-* 3 - Template execution error.
-
+{{ range .exitCodes }}* {{ .Code }} - {{ .Desc }}
+{{ end }}
 ### Template Syntax.
 
 See http://golang.org/pkg/text/template/ for template syntax.
@@ -77,7 +337,7 @@ and [functions](http://golang.org/pkg/text/template/#hdr-Functions) provided by
 the core [template engine](http://golang.org/pkg/text/template/#pkg-overview),
 {{ .cmd }} provides the following functions for use in your templates:
 
-{{ range .funcs }}* [{{ .Name }}](#{{ .Name | slugify }}) - {{ index (split .Short ".") 0 }}.
+{{ range .funcs }}* [{{ .Name }}](#{{ .Name | slugify }}) - {{ .Summary }}
 {{ end }}
 {{ range .funcs }}{{ template "funcHelp" . }}{{ end }}
 `
@@ -85,14 +345,16 @@ the core [template engine](http://golang.org/pkg/text/template/#pkg-overview),
 const funcHelpTemplate = `### {{ .Name }}
 
 {{ .Short | wordWrap 80 }}
-{{ range $ex, $out := .Example }}
+{{ range .Examples }}
 Template:
 
-{{ $ex | linePrefix "    " }}
+{{ .Template | linePrefix "    " }}
 
 Output:
 
-{{ $out | linePrefix "    " }}
+{{ .Output | linePrefix "    " }}
+{{ end }}{{ if .Error }}
+Examples could not be rendered: {{ .Error }}
 {{ end }}
 `
 
@@ -100,11 +362,30 @@ const exitOk = 0
 const exitUsage = 1
 const exitTemplateParseError = 2
 const exitTemplateExecutionError = 3
+const exitOutputError = 4
+const exitMissingVariable = 5
+const exitDataError = 6
+const exitCommandError = 7
+const exitTestFailure = 8
+const exitDiff = 9
+
+type exitCode struct {
+	Code int
+	Desc string
+}
 
-var (
-	funcMap         = newTmplFuncMap()
-	funcHelpExample = false
-)
+var exitCodes = []exitCode{
+	{exitOk, "OK."},
+	{exitUsage, "Usage."},
+	{exitTemplateParseError, "Template parse error."},
+	{exitTemplateExecutionError, "Template execution error."},
+	{exitOutputError, "Output error."},
+	{exitMissingVariable, "Missing environment variable (with -strict or front matter)."},
+	{exitDataError, "Data error."},
+	{exitCommandError, "Command error."},
+	{exitTestFailure, "Test failure (with -test)."},
+	{exitDiff, "Output differs (with -diff)."},
+}
 
 func main() {
 	os.Exit(new(os.Environ(), os.Args, os.Stdin, os.Stdout, os.Stderr).main())
@@ -116,218 +397,516 @@ func new(
 	stdin io.Reader,
 	stdout io.Writer,
 	stderr io.Writer,
-) *envtmpl {
+) *cli {
 	f := flag.NewFlagSet(filepath.Base(args[0]), flag.ExitOnError)
-	app := &envtmpl{
-		stdin:          stdin,
-		stdout:         stdout,
-		stderr:         stderr,
-		env:            env,
-		cmd:            filepath.Base(args[0]),
-		flag:           f,
-		flagHelp:       f.Bool("h", false, "Display help information, including function list."),
-		flagDelimLeft:  f.String("dl", "{{", "Left-hand action delimiter."),
-		flagDelimRight: f.String("dr", "}}", "Right-hand action delimiter."),
+	app := &cli{
+		stdin:           stdin,
+		stdout:          stdout,
+		stderr:          stderr,
+		env:             env,
+		cmd:             filepath.Base(args[0]),
+		exec:            syscall.Exec,
+		flag:            f,
+		flagHelp:        f.Bool("h", false, "Display help information, including function list."),
+		flagDelimLeft:   f.String("dl", "{{", "Left-hand action delimiter."),
+		flagDelimRight:  f.String("dr", "}}", "Right-hand action delimiter."),
+		flagOutput:      f.String("o", "", "Write to path instead of STDOUT."),
+		flagDiff:        f.String("diff", "", "Display the changes rendering would make to path."),
+		flagMode:        f.String("mode", "", "File mode used with -o."),
+		flagUid:         f.Int("uid", -1, "Owner user id used with -o."),
+		flagGid:         f.Int("gid", -1, "Owner group id used with -o."),
+		flagClean:       f.Bool("i", false, "Start from an empty environment."),
+		flagVerbose:     f.Bool("v", false, "Verbose output."),
+		flagNest:        f.String("nest", "", "Build nested data from variable names split by a separator."),
+		flagContext:     f.Bool("context", false, "Expose .Env, .Args and .Meta to templates."),
+		flagFileSuffix:  f.String("file-suffix", "", "Read variables ending with a suffix, such as _FILE, from the files they name."),
+		flagFrontMatter: f.Bool("front-matter", false, "Read settings from front matter at the top of templates."),
+		flagRecursive:   f.Bool("r", false, "Render every template below srcDir into destDir."),
+		flagManifest:    f.String("manifest", "", "Read tmplName.tmpl:path targets from a file."),
+		flagStrict:      f.Bool("strict", false, "Fail on missing environment variables."),
+		flagVars:        f.Bool("vars", false, "List the environment variables used instead of rendering."),
+		flagFormat:      f.String("format", "", "Output format for -h (markdown, text, json or man) or -vars (text or json)."),
+		flagWatch:       f.Bool("watch", false, "Re-render whenever templates or data files change."),
+		flagWatchEvery:  f.Duration("watch-interval", time.Second, "How often to check for changes with -watch."),
+		flagErrorFormat: f.String("error-format", "text", "Error format: text, pretty or json."),
+		flagCheck:       f.Bool("check", false, "Check tmplDir/*.tmpl for problems without rendering."),
+		flagTest:        f.Bool("test", false, "Check templates against tmplDir/*.golden fixtures."),
+		flagUpdate:      f.Bool("update", false, "Rewrite the output of failing fixtures with -test."),
 	}
+	f.Var(&app.flagTargets, "t", "Render tmplName.tmpl:path. Can be repeated.")
+	f.Var(&app.flagPatterns, "pattern", "Parse files in tmplDir matching a glob, *.tmpl by default. Can be repeated.")
+	f.Var(&app.flagIncludes, "I", "Also parse templates from a directory. Can be repeated.")
+	f.Var(&app.flagAllow, "allow", "Only expose environment variables matching a glob or /regexp/. Can be repeated.")
+	f.Var(&app.flagDeny, "deny", "Hide environment variables matching a glob or /regexp/. Can be repeated.")
+	f.Var(&app.flagFileVars, "file-var", "Only read variables matching a glob or /regexp/ from files with -file-suffix. Can be repeated.")
+	f.Var(&app.flagEnvFiles, "env-file", "Load variables from a .env file. Can be repeated.")
+	f.Var(&app.flagData, "data", "Expose a JSON, YAML or TOML file as .Data.name. Can be repeated.")
+	f.Var(varFlag{"var", &app.overrides}, "var", "Set .KEY to a string value. Can be repeated.")
+	f.Var(varFlag{"var-json", &app.overrides}, "var-json", "Set .KEY to a JSON value. Can be repeated.")
+	f.Var(varFlag{"var-file", &app.overrides}, "var-file", "Set .KEY to the contents of a file. Can be repeated.")
 	app.flag.Usage = app.usage
 	app.flag.Parse(args[1:])
 	return app
 }
 
-type envtmpl struct {
-	env            []string
-	stdin          io.Reader
-	stdout         io.Writer
-	stderr         io.Writer
-	cmd            string
-	flag           *flag.FlagSet
-	flagHelp       *bool
-	flagDelimLeft  *string
-	flagDelimRight *string
+type cli struct {
+	env             []string
+	stdin           io.Reader
+	stdinText       []byte
+	tmplFiles       map[string]string
+	frontMatter     map[string]*frontMatter
+	stdout          io.Writer
+	stderr          io.Writer
+	cmd             string
+	exec            func(argv0 string, argv []string, envv []string) error
+	flag            *flag.FlagSet
+	flagHelp        *bool
+	flagDelimLeft   *string
+	flagDelimRight  *string
+	flagOutput      *string
+	flagDiff        *string
+	flagMode        *string
+	flagUid         *int
+	flagGid         *int
+	flagTargets     stringsFlag
+	flagManifest    *string
+	flagPatterns    stringsFlag
+	flagIncludes    stringsFlag
+	flagRecursive   *bool
+	flagFrontMatter *bool
+	flagNest        *string
+	flagFileSuffix  *string
+	flagFileVars    stringsFlag
+	flagContext     *bool
+	flagClean       *bool
+	flagAllow       stringsFlag
+	flagDeny        stringsFlag
+	flagVerbose     *bool
+	flagStrict      *bool
+	flagVars        *bool
+	flagFormat      *string
+	flagWatch       *bool
+	flagWatchEvery  *time.Duration
+	flagErrorFormat *string
+	flagCheck       *bool
+	flagTest        *bool
+	flagUpdate      *bool
+	flagEnvFiles    stringsFlag
+	flagData        stringsFlag
+	overrides       []varOverride
 }
 
-func (app *envtmpl) main() int {
+func (app *cli) main() int {
 	if *app.flagHelp {
-		app.helpUsage()
-		return exitUsage
+		return app.help()
 	}
 	args := app.flag.Args()
-	var tmplDir string
-	var tmplName string
-	tmplData := make(map[string]string)
-	switch len(args) {
-	case 1:
+	var command []string
+	for i, a := range args {
+		if a == "--" {
+			args, command = args[:i], args[i+1:]
+			if len(command) == 0 {
+				app.flag.Usage()
+				return exitUsage
+			}
+			break
+		}
+	}
+	switch *app.flagFormat {
+	case "", "text", "json":
+	default:
+		fmt.Fprintf(app.stderr, "Unknown format '%s'.\n", *app.flagFormat)
+		return exitUsage
+	}
+	if !errorFormats[*app.flagErrorFormat] {
+		fmt.Fprintf(app.stderr, "Unknown error format '%s'.\n", *app.flagErrorFormat)
+		return exitUsage
+	}
+	if *app.flagCheck {
+		if len(args) != 1 || args[0] == "-" || command != nil {
+			app.flag.Usage()
+			return exitUsage
+		}
+		return app.check(args[0])
+	}
+	if *app.flagTest {
+		if len(args) != 1 || args[0] == "-" || command != nil {
+			app.flag.Usage()
+			return exitUsage
+		}
+		return app.test(args[0])
+	}
+	if *app.flagUpdate {
+		fmt.Fprintln(app.stderr, "-update can only be used with -test.")
+		return exitUsage
+	}
+	j := &job{batch: len(app.flagTargets) > 0 || *app.flagManifest != ""}
+	switch {
+	case *app.flagRecursive:
+		if j.batch || len(args) != 2 || args[0] == "-" || *app.flagOutput != "" {
+			app.flag.Usage()
+			return exitUsage
+		}
+		j.tmplDir, j.destDir = args[0], args[1]
+		j.batch = true
+	case j.batch && len(args) == 1 && args[0] != "-" && *app.flagOutput == "":
+		j.tmplDir = args[0]
+		for _, t := range app.flagTargets {
+			tg, err := parseTarget(t)
+			if err != nil {
+				fmt.Fprintf(app.stderr, "Invalid target: %s\n", err)
+				return exitUsage
+			}
+			j.targets = append(j.targets, tg)
+		}
+		if *app.flagManifest != "" {
+			tg, err := readManifest(*app.flagManifest)
+			if err != nil {
+				fmt.Fprintf(app.stderr, "Invalid manifest: %s\n", err)
+				return exitUsage
+			}
+			j.targets = append(j.targets, tg...)
+		}
+	case !j.batch && len(args) == 1:
 		if args[0] == "-" {
-			tmplDir = "-"
-			tmplName = "stdin"
+			j.tmplDir = "-"
+			j.targets = []target{{name: "stdin", output: *app.flagOutput, auto: *app.flagOutput == ""}}
 		} else {
-			tmplDir = filepath.Dir(args[0])
-			tmplName = filepath.Base(args[0])
+			j.tmplDir = filepath.Dir(args[0])
+			j.targets = []target{{name: filepath.Base(args[0]), output: *app.flagOutput, auto: *app.flagOutput == ""}}
 		}
-	case 2:
-		tmplDir = args[0]
-		tmplName = args[1]
+	case !j.batch && (len(args) == 2 || len(args) > 2 && *app.flagContext):
+		j.tmplDir = args[0]
+		j.targets = []target{{name: args[1], output: *app.flagOutput, auto: *app.flagOutput == ""}}
+		j.args = args[2:]
 	default:
 		app.flag.Usage()
 		return exitUsage
 	}
-	tmpl := template.New(
-		fmt.Sprintf("%s [%s]", app.cmd, tmplDir),
-	)
-	tmpl.Delims(*app.flagDelimLeft, *app.flagDelimRight)
-	tmpl.Funcs(funcMap.funcs(tmpl))
-
-	var err error
-	if tmplDir == "-" && tmplName == "stdin" {
-		var b bytes.Buffer
-		b.ReadFrom(app.stdin)
-		_, err = tmpl.New("stdin").Parse(b.String())
-	} else {
-		_, err = tmpl.ParseGlob(filepath.Join(tmplDir, "*.tmpl"))
+	if _, err := app.envFilter(); err != nil {
+		fmt.Fprintf(app.stderr, "Invalid pattern: %s\n", err)
+		return exitUsage
 	}
-	if err != nil {
-		fmt.Fprintf(app.stderr, "Template parse error: %s\n", err)
-		return exitTemplateParseError
+	if _, err := app.fileVarPatterns(); err != nil {
+		fmt.Fprintf(app.stderr, "Invalid pattern: %s\n", err)
+		return exitUsage
 	}
-
-	for _, s := range app.env {
-		o := strings.Index(s, "=")
-		if o <= 0 {
-			continue
+	if *app.flagFileSuffix == "" && len(app.flagFileVars) > 0 {
+		fmt.Fprintln(app.stderr, "-file-var requires -file-suffix.")
+		return exitUsage
+	}
+	if *app.flagMode != "" {
+		m, err := strconv.ParseUint(*app.flagMode, 8, 32)
+		if err != nil || os.FileMode(m)&^os.ModePerm != 0 {
+			fmt.Fprintf(app.stderr, "Invalid file mode '%s'.\n", *app.flagMode)
+			return exitUsage
 		}
-		tmplData[s[:o]] = s[o+1:]
+		j.perm = os.FileMode(m)
 	}
-
-	err = tmpl.ExecuteTemplate(app.stdout, tmplName, tmplData)
-	if err != nil {
-		fmt.Fprintf(app.stderr, "Template execution: %s\n", err)
-		return exitTemplateExecutionError
+	if *app.flagDiff != "" {
+		if j.batch || *app.flagOutput != "" || *app.flagWatch || *app.flagVars || command != nil {
+			fmt.Fprintln(app.stderr, "-diff can't be used with -o, -t, -manifest, -r, -watch, -vars or a command.")
+			return exitUsage
+		}
+		j.diff = true
+		j.targets[0].output = *app.flagDiff
+		j.targets[0].auto = false
 	}
-	return exitOk
+	if *app.flagRecursive && (*app.flagWatch || *app.flagVars) {
+		fmt.Fprintln(app.stderr, "-r can't be used with -watch or -vars.")
+		return exitUsage
+	}
+	if command != nil && (*app.flagWatch || *app.flagVars) {
+		fmt.Fprintln(app.stderr, "A command can't be used with -watch or -vars.")
+		return exitUsage
+	}
+	if *app.flagWatch {
+		if j.tmplDir == "-" || *app.flagVars {
+			fmt.Fprintln(app.stderr, "Watch mode can't be used with STDIN or -vars.")
+			return exitUsage
+		}
+		for _, t := range j.targets {
+			if t.output == "" {
+				fmt.Fprintln(app.stderr, "Watch mode requires an output path, see -o and -t.")
+				return exitUsage
+			}
+		}
+		return app.watch(j, nil)
+	}
+	code := app.execute(j)
+	if code != exitOk || command == nil {
+		return code
+	}
+	path, err := exec.LookPath(command[0])
+	if err == nil {
+		err = app.exec(path, command, app.env)
+	}
+	fmt.Fprintf(app.stderr, "Command error: %s\n", err)
+	return exitCommandError
 }
 
-func (app *envtmpl) usage() {
-	t := template.New("usage")
-	t = template.Must(
-		t.Funcs(funcMap.funcs(t)).Parse(usageTemplate),
-	)
-	var u bytes.Buffer
-	t.Execute(&u, map[string]interface{}{
-		"version": Version,
-		"cmd":     filepath.Base(app.cmd),
-	})
-	fmt.Fprintf(app.stderr, "%s\n", bytes.TrimSpace(u.Bytes()))
+// job is a resolved invocation: where the templates are, what to render and
+// how to write it.
+type job struct {
+	tmplDir string
+	destDir string
+	targets []target
+	perm    os.FileMode
+	batch   bool
+	diff    bool
+	// args are the positional arguments after tmplName.tmpl, exposed as
+	// .Args with -context.
+	args []string
 }
 
-func (app *envtmpl) helpUsage() {
-	funcHelpExample = true
-	defer func() { funcHelpExample = false }()
-	t := template.New("help")
-	t = template.Must(
-		t.Funcs(funcMap.funcs(t)).Parse(helpTemplate),
-	)
-	template.Must(t.New("funcHelp").Parse(funcHelpTemplate))
-
-	type funcData struct {
-		Name    string
-		Short   string
-		Example map[string]string
+// execute parses the templates and renders every target of the job.
+func (app *cli) execute(j *job) int {
+	if j.destDir != "" {
+		return app.renderTree(j)
 	}
-	var u bytes.Buffer
-	funcs := make(map[string]funcData)
-	for n, fn := range funcMap {
-		fd := funcData{
-			Name:    n,
-			Short:   fn.shortUsage(),
-			Example: make(map[string]string),
-		}
-		for _, e := range fn.example(n) {
-			var b bytes.Buffer
-			t := template.New(n)
-			err := template.Must(
-				t.Funcs(funcMap.funcs(t)).Parse(e),
-			).Execute(&b, struct{ FOO string }{FOO: "foo"})
-			if err != nil {
-				panic(err)
-			}
-			fd.Example[e] = b.String()
-		}
-		funcs[n] = fd
+	r, err := app.parse(j.tmplDir)
+	if err != nil {
+		return app.parseFailure(j.tmplDir, err)
 	}
-	cmd := filepath.Base(app.cmd)
-	err := t.Execute(&u, map[string]interface{}{
-		"cmd":        cmd,
-		"funcs":      funcs,
-		"usage1":     cmd + " tmplDir tmplName.tmpl",
-		"usage2":     cmd + " tmplDir/tmplName.tmpl",
-		"usageStdin": cmd + " -",
-	})
+
+	if *app.flagVars {
+		return app.vars(r, j)
+	}
+
+	tmplData, err := app.data()
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(app.stderr, "Data error: %s\n", err)
+		return exitDataError
 	}
-	fmt.Fprintf(app.stderr, "%s\n", bytes.TrimSpace(u.Bytes()))
-}
 
-type tmplFuncMap map[string]tmplFunc
+	return app.render(r, j, tmplData)
+}
 
-func newTmplFuncMap() tmplFuncMap {
-	return make(tmplFuncMap)
+// runtime returns the Runtime used by template functions, looking up
+// variables in app.env, subject to -i, -allow and -deny, rather than the
+// process environment.
+func (app *cli) runtime() *envtmpl.Runtime {
+	rt := envtmpl.DefaultRuntime()
+	f, _ := app.envFilter()
+	rt.LookupEnv = func(key string) (string, bool) {
+		if f == nil || f.reason(key) != "" {
+			return "", false
+		}
+		return app.lookupEnv(key)
+	}
+	return rt
 }
 
-func (m *tmplFuncMap) funcs(t *template.Template) map[string]interface{} {
-	funcs := make(template.FuncMap)
-	for k, v := range funcMap {
-		funcs[k] = v.f(t)
+// lookupEnv looks up key in app.env, ignoring -i, -allow and -deny, which
+// only apply to what templates see.
+func (app *cli) lookupEnv(key string) (string, bool) {
+	for i := len(app.env) - 1; i >= 0; i-- {
+		s := app.env[i]
+		if o := strings.Index(s, "="); o > 0 && s[:o] == key {
+			return s[o+1:], true
+		}
 	}
-	return funcs
+	return "", false
 }
 
-type tmplFunc interface {
-	shortUsage() string
-	example(name string) []string
-	f(*template.Template) interface{}
+// renderer creates a Renderer for tmplDir configured by the flags. Any
+// front matter from an earlier parse is forgotten.
+func (app *cli) renderer(tmplDir string) *envtmpl.Renderer {
+	app.frontMatter = make(map[string]*frontMatter)
+	opts := []envtmpl.Option{
+		envtmpl.WithDelims(*app.flagDelimLeft, *app.flagDelimRight),
+		envtmpl.WithRuntime(app.runtime()),
+	}
+	if *app.flagStrict {
+		opts = append(opts, envtmpl.WithStrict())
+	}
+	return envtmpl.New(fmt.Sprintf("%s [%s]", app.cmd, tmplDir), opts...)
 }
 
-type tmplFuncStruct struct {
-	short    string
-	examples []string
-	fn       interface{}
+// parse parses tmplDir/*.tmpl, or STDIN when tmplDir is a dash.
+func (app *cli) parse(tmplDir string) (*envtmpl.Renderer, error) {
+	r := app.renderer(tmplDir)
+
+	var err error
+	if tmplDir == "-" {
+		var b bytes.Buffer
+		b.ReadFrom(app.stdin)
+		app.stdinText = b.Bytes()
+		var src envtmpl.Source
+		if src, err = app.source("stdin", "stdin", b.Bytes()); err == nil {
+			err = r.ParseSources(src)
+		}
+	} else {
+		var files []string
+		if files, err = app.templateFiles(tmplDir); err == nil {
+			err = app.parseFiles(r, files)
+		}
+	}
+	return r, err
 }
 
-func (s *tmplFuncStruct) shortUsage() string {
-	return s.short
+// patterns returns the -pattern globs, which default to *.tmpl.
+func (app *cli) patterns() []string {
+	if len(app.flagPatterns) == 0 {
+		return []string{"*.tmpl"}
+	}
+	return app.flagPatterns
 }
 
-func (s *tmplFuncStruct) example(name string) []string {
-	e := make([]string, len(s.examples))
-	for k, v := range s.examples {
-		e[k] = fmt.Sprintf(v, name)
+// templateFiles returns the files in each -I directory and then tmplDir that
+// match a -pattern, so that templates in tmplDir can override the blocks of
+// shared partials. Templates are named after the base name of their file, so
+// it is an error for two of them to share a base name.
+func (app *cli) templateFiles(tmplDir string) ([]string, error) {
+	var files, globs []string
+	app.tmplFiles = make(map[string]string)
+	for _, dir := range append(append([]string{}, app.flagIncludes...), tmplDir) {
+		for _, p := range app.patterns() {
+			glob := filepath.Join(dir, p)
+			globs = append(globs, glob)
+			matches, err := filepath.Glob(glob)
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range matches {
+				name := filepath.Base(file)
+				if f, ok := app.tmplFiles[name]; ok {
+					if f == file {
+						continue
+					}
+					return nil, fmt.Errorf("template: %s: %q is already defined by %s", file, name, f)
+				}
+				app.tmplFiles[name] = file
+				files = append(files, file)
+			}
+		}
 	}
-	return e
+	if len(files) == 0 {
+		return nil, fmt.Errorf("template: pattern matches no files: %#q", strings.Join(globs, ", "))
+	}
+	return files, nil
 }
 
-func (s *tmplFuncStruct) f(_ *template.Template) interface{} {
-	return s.fn
+// data builds the template data from the environment, any .env files and
+// any structured data files.
+func (app *cli) data() (map[string]interface{}, error) {
+	env, err := app.environ()
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string)
+	lookup := func(k string) string {
+		if v, ok := env[k]; ok {
+			return v
+		}
+		return vars[k]
+	}
+	set := func(k, v string) {
+		vars[k] = v
+	}
+	for _, file := range app.flagEnvFiles {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		err = parseEnvFile(file, f, lookup, set)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range env {
+		vars[k] = v
+	}
+	if *app.flagFileSuffix != "" {
+		patterns, err := app.fileVarPatterns()
+		if err != nil {
+			return nil, err
+		}
+		if err := fileVars(vars, *app.flagFileSuffix, patterns); err != nil {
+			return nil, err
+		}
+	}
+	data := make(map[string]interface{}, len(vars)+1)
+	for k, v := range vars {
+		data[k] = v
+	}
+	if *app.flagNest != "" {
+		nested, err := nestVars(vars, *app.flagNest)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range nested {
+			data[k] = v
+		}
+	}
+	for _, o := range app.overrides {
+		name, v, err := o.value()
+		if err != nil {
+			return nil, err
+		}
+		data[name] = v
+	}
+	if len(app.flagData) > 0 {
+		d := make(map[string]interface{})
+		for _, s := range app.flagData {
+			name, format, file, err := parseDataFlag(s)
+			if err != nil {
+				return nil, err
+			}
+			d[name], err = loadDataFile(file, format)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err)
+			}
+		}
+		data["Data"] = d
+	}
+	return data, nil
 }
 
-type tmplFuncFactoryStruct struct {
-	short    string
-	examples []string
-	fn       func(*template.Template) interface{}
+func (app *cli) usage() {
+	r := envtmpl.New("usage")
+	must(r.Parse("usage", usageTemplate))
+	var u bytes.Buffer
+	r.Render(&u, "usage", map[string]interface{}{
+		"version": Version,
+		"cmd":     filepath.Base(app.cmd),
+	})
+	fmt.Fprintf(app.stderr, "%s\n", bytes.TrimSpace(u.Bytes()))
 }
 
-func (s *tmplFuncFactoryStruct) shortUsage() string {
-	return s.short
+func (app *cli) helpUsage() {
+	r := envtmpl.New("help")
+	must(r.Parse("help", helpTemplate))
+	must(r.Parse("funcHelp", funcHelpTemplate))
+
+	docs := envtmpl.Docs()
+	var u bytes.Buffer
+	must(r.Render(&u, "help", app.helpData(docs)))
+	fmt.Fprintf(app.stderr, "%s\n", bytes.TrimSpace(u.Bytes()))
 }
 
-func (s *tmplFuncFactoryStruct) example(name string) []string {
-	e := make([]string, len(s.examples))
-	for k, v := range s.examples {
-		e[k] = fmt.Sprintf(v, name)
+// helpData is the data used by the help templates.
+func (app *cli) helpData(docs []envtmpl.FuncDoc) map[string]interface{} {
+	cmd := filepath.Base(app.cmd)
+	return map[string]interface{}{
+		"cmd":          cmd,
+		"version":      Version,
+		"funcs":        docs,
+		"exitCodes":    exitCodes,
+		"usage1":       cmd + " tmplDir tmplName.tmpl",
+		"usage2":       cmd + " tmplDir/tmplName.tmpl",
+		"usageStdin":   cmd + " -",
+		"usageBatch":   cmd + " -t tmplName.tmpl:path [-t ...] tmplDir",
+		"usageTree":    cmd + " -r srcDir destDir",
+		"usageCommand": cmd + " -o path tmplDir tmplName.tmpl -- command [args...]",
+		"usageCheck":   cmd + " -check tmplDir",
+		"usageTest":    cmd + " -test [-update] tmplDir",
 	}
-	return e
 }
 
-func (s *tmplFuncFactoryStruct) f(t *template.Template) interface{} {
-	return s.fn(t)
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
 }
@@ -2,10 +2,18 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/williambailey/go-envtmpl"
 )
 
 func run(t *testing.T, env []string, args []string, stdin *[]byte) (int, *bytes.Buffer, *bytes.Buffer) {
@@ -76,12 +84,12 @@ func TestInvokeWithHelpFlagDisplaysHelpAndExitsWithUsage(t *testing.T) {
 		t.Errorf("Expecting stderr to start `%s` got `%s`", u, e.Bytes()[:len(u)])
 	}
 	// Check that we have all available funcs listed.
-	for n, fn := range funcMap {
+	for n, fn := range envtmpl.Funcs() {
 		b := []byte("\n### " + n + "\n")
 		if !bytes.Contains(e.Bytes(), b) {
 			t.Errorf("Expecting stderr to contain `%s`", b)
 		}
-		for _, fex := range fn.example(n) {
+		for _, fex := range fn.Example(n) {
 			var b bytes.Buffer
 			for _, v := range strings.SplitAfter(fex, "\n") {
 				b.WriteString("    ")
@@ -322,3 +330,1384 @@ func TestInvokeWithCustomRightHandDelimiterAndSimpleTemplateSingleCommandArg(t *
 		t.Errorf("Expecting stdout to equal `%s` got `%s`", ex, o.Bytes())
 	}
 }
+
+func TestInvokeWithOutputFlagWritesFile(t *testing.T) {
+	fo, _ := os.Create("foo.tmpl")
+	defer os.Remove("foo.tmpl")
+	fo.Write([]byte(`Hello {{.WHAT}}!`))
+	fo.Close()
+	defer os.Remove("foo.out")
+	r, o, e := run(t, []string{"WHAT=World"}, []string{"me", "-o", "foo.out", "-mode", "0640", "./foo.tmpl"}, nil)
+	if r != exitOk {
+		t.Errorf(
+			"Expecting application to terminate with ExitOk, %d, got %d.",
+			exitOk,
+			r,
+		)
+	}
+	if e.Len() != 0 {
+		t.Errorf("Expecting stderr len to be 0, got %d", e.Len())
+	}
+	if o.Len() != 0 {
+		t.Errorf("Expecting stdout len to be 0, got %d", o.Len())
+	}
+	b, err := ioutil.ReadFile("foo.out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex := []byte(`Hello World!`)
+	if !bytes.Equal(b, ex) {
+		t.Errorf("Expecting foo.out to equal `%s` got `%s`", ex, b)
+	}
+	fi, err := os.Stat("foo.out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Errorf("Expecting foo.out mode to be 0640, got %#o", fi.Mode().Perm())
+	}
+}
+
+func TestInvokeWithOutputFlagKeepsOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of a file needs root")
+	}
+	ioutil.WriteFile("foo.tmpl", []byte(`new`), 0644)
+	defer os.Remove("foo.tmpl")
+	ioutil.WriteFile("foo.out", []byte("old"), 0644)
+	defer os.Remove("foo.out")
+	if err := os.Chown("foo.out", 1234, 5678); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		args     []string
+		uid, gid uint32
+	}{
+		{[]string{"me", "-o", "foo.out", "./foo.tmpl"}, 1234, 5678},
+		{[]string{"me", "-o", "foo.out", "-gid", "42", "./foo.tmpl"}, 1234, 42},
+	} {
+		r, _, e := run(t, []string{}, c.args, nil)
+		if r != exitOk {
+			t.Errorf("Expecting application to terminate with ExitOk, %d, got %d.", exitOk, r)
+			t.Error(e)
+		}
+		fi, err := os.Stat("foo.out")
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if st.Uid != c.uid || st.Gid != c.gid {
+			t.Errorf("Expecting foo.out to be owned by %d:%d with %v, got %d:%d", c.uid, c.gid, c.args, st.Uid, st.Gid)
+		}
+	}
+}
+
+func TestInvokeWithOutputFlagLeavesFileUntouchedOnExecutionError(t *testing.T) {
+	fo, _ := os.Create("foo.tmpl")
+	defer os.Remove("foo.tmpl")
+	fo.Write([]byte(`Hello {{ "x" | hexDecode }}!`))
+	fo.Close()
+	ioutil.WriteFile("foo.out", []byte("old"), 0644)
+	defer os.Remove("foo.out")
+	r, _, _ := run(t, []string{}, []string{"me", "-o", "foo.out", "./foo.tmpl"}, nil)
+	if r != exitTemplateExecutionError {
+		t.Errorf(
+			"Expecting application to terminate with ExitTemplateExecutionError, %d, got %d.",
+			exitTemplateExecutionError,
+			r,
+		)
+	}
+	b, _ := ioutil.ReadFile("foo.out")
+	if string(b) != "old" {
+		t.Errorf("Expecting foo.out to be untouched, got `%s`", b)
+	}
+	m, _ := filepath.Glob(".foo.out.*")
+	if len(m) != 0 {
+		t.Errorf("Expecting temporary files to be removed, got %v", m)
+	}
+}
+
+func TestInvokeWithTargetsRendersEachTarget(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte(`Hello {{.WHAT}}!`), 0644)
+	defer os.Remove("foo.tmpl")
+	ioutil.WriteFile("bar.tmpl", []byte(`Bye {{.WHAT}}!`), 0644)
+	defer os.Remove("bar.tmpl")
+	ioutil.WriteFile("foo.manifest", []byte("# comment\n\nbar.tmpl:bar.out\n"), 0644)
+	defer os.Remove("foo.manifest")
+	defer os.Remove("foo.out")
+	defer os.Remove("bar.out")
+	r, o, e := run(
+		t,
+		[]string{"WHAT=World"},
+		[]string{"me", "-t", "foo.tmpl:foo.out", "-t", "foo.tmpl:-", "-manifest", "foo.manifest", "."},
+		nil,
+	)
+	if r != exitOk {
+		t.Errorf(
+			"Expecting application to terminate with ExitOk, %d, got %d.",
+			exitOk,
+			r,
+		)
+	}
+	if e.Len() != 0 {
+		t.Errorf("Expecting stderr len to be 0, got %d", e.Len())
+	}
+	ex := []byte(`Hello World!`)
+	if !bytes.Equal(o.Bytes(), ex) {
+		t.Errorf("Expecting stdout to equal `%s` got `%s`", ex, o.Bytes())
+	}
+	for f, ex := range map[string]string{"foo.out": "Hello World!", "bar.out": "Bye World!"} {
+		b, _ := ioutil.ReadFile(f)
+		if string(b) != ex {
+			t.Errorf("Expecting %s to equal `%s` got `%s`", f, ex, b)
+		}
+	}
+}
+
+func TestInvokeWithTargetsReportsFailuresWithSummary(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte(`Hello {{.WHAT}}!`), 0644)
+	defer os.Remove("foo.tmpl")
+	defer os.Remove("foo.out")
+	r, _, e := run(
+		t,
+		[]string{"WHAT=World"},
+		[]string{"me", "-t", "bar.tmpl:bar.out", "-t", "foo.tmpl:foo.out", "."},
+		nil,
+	)
+	if r != exitTemplateExecutionError {
+		t.Errorf(
+			"Expecting application to terminate with ExitTemplateExecutionError, %d, got %d.",
+			exitTemplateExecutionError,
+			r,
+		)
+	}
+	ex := []byte("1 of 2 targets failed (1 execution, 0 missing variable, 0 output).")
+	if !bytes.HasSuffix(bytes.TrimSpace(e.Bytes()), ex) {
+		t.Errorf("Expecting stderr to end `%s` got `%s`", ex, e.Bytes())
+	}
+	b, _ := ioutil.ReadFile("foo.out")
+	if string(b) != "Hello World!" {
+		t.Errorf("Expecting foo.out to be rendered, got `%s`", b)
+	}
+}
+
+func TestInvokeWithStrictAndMissingVariableExitsWithMissingVariable(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte("Hello\n{{.WHAT}}!"), 0644)
+	defer os.Remove("foo.tmpl")
+	r, o, e := run(t, []string{}, []string{"me", "-strict", ".", "foo.tmpl"}, nil)
+	if r != exitMissingVariable {
+		t.Errorf(
+			"Expecting application to terminate with ExitMissingVariable, %d, got %d.",
+			exitMissingVariable,
+			r,
+		)
+	}
+	if o.String() != "Hello\n" {
+		t.Errorf("Expecting stdout to equal `Hello\n` got `%s`", o.Bytes())
+	}
+	ex := []byte("Missing environment variable: WHAT (foo.tmpl:2:2)")
+	if !bytes.Equal(bytes.TrimSpace(e.Bytes()), ex) {
+		t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.Bytes())
+	}
+}
+
+func TestInvokeWithStrictAndMissingKeyExitsWithExecutionError(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte("{{.Data.cfg.missing}}"), 0644)
+	defer os.Remove("foo.tmpl")
+	ioutil.WriteFile("bar.tmpl", []byte("{{with .Data.cfg}}{{.missing}}{{end}}"), 0644)
+	defer os.Remove("bar.tmpl")
+	ioutil.WriteFile("baz.tmpl", []byte(`{{ define "db" }}{{ .host }}{{ end }}{{ template "db" .Data.cfg }}`), 0644)
+	defer os.Remove("baz.tmpl")
+	ioutil.WriteFile("qux.tmpl", []byte(`{{ define "api" }}{{ $.url }}{{ end }}{{ include "api" .Data.cfg }}`), 0644)
+	defer os.Remove("qux.tmpl")
+	ioutil.WriteFile("foo.json", []byte(`{"cfg": {}}`), 0644)
+	defer os.Remove("foo.json")
+	for name, ex := range map[string]string{
+		"foo.tmpl": "Template execution: missing key missing in <.Data.cfg.missing> (foo.tmpl:1:7)",
+		"bar.tmpl": "Template execution: missing key missing in <.missing> (bar.tmpl:1:20)",
+		"baz.tmpl": "Template execution: missing key host in <.host> (baz.tmpl:1:20)",
+		"qux.tmpl": "Template execution: missing key url in <$.url> (qux.tmpl:1:22)",
+	} {
+		r, _, e := run(t, []string{}, []string{"me", "-strict", "-data", "cfg=foo.json", ".", name}, nil)
+		if r != exitTemplateExecutionError {
+			t.Errorf(
+				"Expecting %s to terminate with ExitTemplateExecutionError, %d, got %d.",
+				name,
+				exitTemplateExecutionError,
+				r,
+			)
+		}
+		if strings.TrimSpace(e.String()) != ex {
+			t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.String())
+		}
+	}
+}
+
+func TestInvokeWithStrictAndMissingVariableInTemplateCall(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte(`{{ define "env" }}{{ .WHAT }}{{ end }}{{ template "env" . }}`), 0644)
+	defer os.Remove("foo.tmpl")
+	r, _, e := run(t, []string{}, []string{"me", "-strict", ".", "foo.tmpl"}, nil)
+	if r != exitMissingVariable {
+		t.Errorf(
+			"Expecting application to terminate with ExitMissingVariable, %d, got %d.",
+			exitMissingVariable,
+			r,
+		)
+	}
+	ex := "Missing environment variable: WHAT (foo.tmpl:1:21)"
+	if strings.TrimSpace(e.String()) != ex {
+		t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.String())
+	}
+}
+
+func TestInvokeWithStrictAndAllVariablesSet(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte(`Hello {{.WHAT}}!`), 0644)
+	defer os.Remove("foo.tmpl")
+	r, o, _ := run(t, []string{"WHAT=World"}, []string{"me", "-strict", ".", "foo.tmpl"}, nil)
+	if r != exitOk {
+		t.Errorf(
+			"Expecting application to terminate with ExitOk, %d, got %d.",
+			exitOk,
+			r,
+		)
+	}
+	ex := []byte(`Hello World!`)
+	if !bytes.Equal(o.Bytes(), ex) {
+		t.Errorf("Expecting stdout to equal `%s` got `%s`", ex, o.Bytes())
+	}
+}
+
+func TestParseEnvFile(t *testing.T) {
+	src := `# A comment.
+A=plain value # trailing comment
+export B = 'single $A \n'
+C="double ${A}\t\"quoted\"\
+ continued"
+D="multi
+line"
+E=$A/$UNSET/${B}
+F=a#b
+
+G=""
+`
+	ex := map[string]string{
+		"A": "plain value",
+		"B": `single $A \n`,
+		"C": "double plain value\t\"quoted\" continued",
+		"D": "multi\nline",
+		"E": `plain value//single $A \n`,
+		"F": "a#b",
+		"G": "",
+	}
+	data := make(map[string]string)
+	err := parseEnvFile(
+		"test.env",
+		strings.NewReader(src),
+		func(k string) string { return data[k] },
+		func(k, v string) { data[k] = v },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range ex {
+		if data[k] != v {
+			t.Errorf("Expecting %s to equal `%s` got `%s`", k, v, data[k])
+		}
+	}
+	if len(data) != len(ex) {
+		t.Errorf("Expecting %d variables, got %d", len(ex), len(data))
+	}
+}
+
+func TestParseEnvFileErrorsReportFileAndLine(t *testing.T) {
+	for src, ex := range map[string]string{
+		"A=1\nB\n":         "test.env:2: expecting '=' after B",
+		"A=1\n\nB='x\ny\n": "test.env:3: unterminated single quoted value",
+		"A=\"x\" y\n":      "test.env:1: unexpected character 'y'",
+		"A=1\n=2\n":        "test.env:2: expecting variable name, got '='",
+		"A=${B\n":          "test.env:1: invalid variable reference, expecting ${NAME}",
+		"A=\"a\"\nB=\"b\n": "test.env:2: unterminated double quoted value",
+	} {
+		err := parseEnvFile(
+			"test.env",
+			strings.NewReader(src),
+			func(string) string { return "" },
+			func(string, string) {},
+		)
+		if err == nil || err.Error() != ex {
+			t.Errorf("Expecting error `%s` for `%s`, got `%v`", ex, src, err)
+		}
+	}
+}
+
+func TestInvokeWithEnvFiles(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte(`{{.A}} {{.B}} {{.C}}`), 0644)
+	defer os.Remove("foo.tmpl")
+	ioutil.WriteFile("foo.env", []byte("A=a1\nB=b1\nC=${B}\n"), 0644)
+	defer os.Remove("foo.env")
+	ioutil.WriteFile("bar.env", []byte("B=b2\n"), 0644)
+	defer os.Remove("bar.env")
+	r, o, e := run(
+		t,
+		[]string{"A=env"},
+		[]string{"me", "-env-file", "foo.env", "-env-file", "bar.env", ".", "foo.tmpl"},
+		nil,
+	)
+	if r != exitOk {
+		t.Errorf(
+			"Expecting application to terminate with ExitOk, %d, got %d.",
+			exitOk,
+			r,
+		)
+	}
+	if e.Len() != 0 {
+		t.Errorf("Expecting stderr len to be 0, got %d", e.Len())
+	}
+	ex := []byte(`env b2 b1`)
+	if !bytes.Equal(o.Bytes(), ex) {
+		t.Errorf("Expecting stdout to equal `%s` got `%s`", ex, o.Bytes())
+	}
+}
+
+func TestInvokeWithInvalidEnvFileExitsWithDataError(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte(`{{.A}}`), 0644)
+	defer os.Remove("foo.tmpl")
+	ioutil.WriteFile("foo.env", []byte("A=1\nB\n"), 0644)
+	defer os.Remove("foo.env")
+	r, o, e := run(t, []string{}, []string{"me", "-env-file", "foo.env", ".", "foo.tmpl"}, nil)
+	if r != exitDataError {
+		t.Errorf(
+			"Expecting application to terminate with ExitDataError, %d, got %d.",
+			exitDataError,
+			r,
+		)
+	}
+	if o.Len() != 0 {
+		t.Errorf("Expecting stdout len to be 0, got %d", o.Len())
+	}
+	ex := []byte("Data error: foo.env:2: expecting '=' after B")
+	if !bytes.Equal(bytes.TrimSpace(e.Bytes()), ex) {
+		t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.Bytes())
+	}
+}
+
+func TestInvokeWithDataFiles(t *testing.T) {
+	ioutil.WriteFile(
+		"foo.tmpl",
+		[]byte(`{{.WHAT}}{{range .Data.j.hosts}} {{.}}{{end}} {{.Data.y.name}} {{.Data.t.port}}`),
+		0644,
+	)
+	defer os.Remove("foo.tmpl")
+	ioutil.WriteFile("foo.json", []byte(`{"hosts": ["a", "b"]}`), 0644)
+	defer os.Remove("foo.json")
+	ioutil.WriteFile("foo.conf", []byte("name: yaml\n"), 0644)
+	defer os.Remove("foo.conf")
+	ioutil.WriteFile("foo.toml", []byte("port = 8080\n"), 0644)
+	defer os.Remove("foo.toml")
+	r, o, e := run(
+		t,
+		[]string{"WHAT=World"},
+		[]string{"me", "-data", "j=foo.json", "-data", "y:yaml=foo.conf", "-data", "t=foo.toml", ".", "foo.tmpl"},
+		nil,
+	)
+	if r != exitOk {
+		t.Errorf(
+			"Expecting application to terminate with ExitOk, %d, got %d.",
+			exitOk,
+			r,
+		)
+	}
+	if e.Len() != 0 {
+		t.Errorf("Expecting stderr len to be 0, got %d", e.Len())
+		t.Error(e)
+	}
+	ex := []byte(`World a b yaml 8080`)
+	if !bytes.Equal(o.Bytes(), ex) {
+		t.Errorf("Expecting stdout to equal `%s` got `%s`", ex, o.Bytes())
+	}
+}
+
+func TestInvokeWithUnknownDataFormatExitsWithDataError(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte(`{{.Data.x}}`), 0644)
+	defer os.Remove("foo.tmpl")
+	r, _, e := run(t, []string{}, []string{"me", "-data", "x=foo.conf", ".", "foo.tmpl"}, nil)
+	if r != exitDataError {
+		t.Errorf(
+			"Expecting application to terminate with ExitDataError, %d, got %d.",
+			exitDataError,
+			r,
+		)
+	}
+	ex := []byte("Data error: foo.conf: unable to detect format from extension '.conf'")
+	if !bytes.Equal(bytes.TrimSpace(e.Bytes()), ex) {
+		t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.Bytes())
+	}
+}
+
+func TestInvokeWithVarsListsVariables(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte(`{{.A}}{{range .B}}{{.NOT}}{{$.C}}{{end}}
+{{template "bar.tmpl" .}}{{include "baz.tmpl" .X}}{{index . "D"}}{{index . .E}}`), 0644)
+	defer os.Remove("foo.tmpl")
+	ioutil.WriteFile("bar.tmpl", []byte(`{{with .F}}{{.NOT}}{{end}}`), 0644)
+	defer os.Remove("bar.tmpl")
+	ioutil.WriteFile("baz.tmpl", []byte(`{{.NOT}}`), 0644)
+	defer os.Remove("baz.tmpl")
+	r, o, e := run(t, []string{}, []string{"me", "-vars", ".", "foo.tmpl"}, nil)
+	if r != exitOk {
+		t.Errorf(
+			"Expecting application to terminate with ExitOk, %d, got %d.",
+			exitOk,
+			r,
+		)
+	}
+	if e.Len() != 0 {
+		t.Errorf("Expecting stderr len to be 0, got %d", e.Len())
+	}
+	ex := []byte(`bar.tmpl:1:8: F
+foo.tmpl:1:3: A
+foo.tmpl:1:15: B
+foo.tmpl:1:30: C
+foo.tmpl:2:47: X
+foo.tmpl:2:61: D
+foo.tmpl:2:68: dynamic lookup: index . .E
+foo.tmpl:2:76: E
+`)
+	if !bytes.Equal(o.Bytes(), ex) {
+		t.Errorf("Expecting stdout to equal `%s` got `%s`", ex, o.Bytes())
+	}
+}
+
+func TestWatchRendersOnChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envtmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tmplFile := filepath.Join(dir, "foo.tmpl")
+	outFile := filepath.Join(dir, "foo.out")
+	ioutil.WriteFile(tmplFile, []byte(`v1 {{.WHAT}}`), 0644)
+	app := new(
+		[]string{"WHAT=World"},
+		[]string{"me", "-watch", "-watch-interval", "5ms", "-o", outFile, tmplFile},
+		nil,
+		ioutil.Discard,
+		ioutil.Discard,
+	)
+	j := &job{tmplDir: dir, targets: []target{{name: "foo.tmpl", output: outFile}}}
+	stop := make(chan struct{})
+	done := make(chan int)
+	go func() { done <- app.watch(j, stop) }()
+	waitFor := func(ex string) {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if b, _ := ioutil.ReadFile(outFile); string(b) == ex {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		b, _ := ioutil.ReadFile(outFile)
+		t.Fatalf("Expecting %s to equal `%s` got `%s`", outFile, ex, b)
+	}
+	// Replace the template atomically so that it is never seen truncated.
+	write := func(s string) {
+		writeFileAtomic(tmplFile, []byte(s), 0644, -1, -1)
+	}
+	waitFor("v1 World")
+	write(`version2 {{.WHAT}}`)
+	waitFor("version2 World")
+	write(`broken {{.WHAT`)
+	time.Sleep(50 * time.Millisecond)
+	waitFor("version2 World")
+	write(`v3 {{.WHAT}}`)
+	waitFor("v3 World")
+	close(stop)
+	if r := <-done; r != exitOk {
+		t.Errorf("Expecting watch to return ExitOk, %d, got %d.", exitOk, r)
+	}
+}
+
+func TestInvokeWithCommandRendersThenExecs(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte(`Hello {{.WHAT}}!`), 0644)
+	defer os.Remove("foo.tmpl")
+	defer os.Remove("foo.out")
+	for _, tc := range []struct {
+		tmpl string
+		code int
+		exec bool
+	}{
+		{"foo.tmpl", exitOk, true},
+		{"bar.tmpl", exitTemplateExecutionError, false},
+	} {
+		var execArgv []string
+		app := new(
+			[]string{"WHAT=World"},
+			[]string{"me", "-o", "foo.out", ".", tc.tmpl, "--", "go", "version"},
+			nil,
+			ioutil.Discard,
+			ioutil.Discard,
+		)
+		app.exec = func(argv0 string, argv []string, envv []string) error {
+			execArgv = argv
+			return nil
+		}
+		r := app.main()
+		if !tc.exec {
+			if execArgv != nil {
+				t.Errorf("Expecting command not to run when rendering %s", tc.tmpl)
+			}
+			if r != tc.code {
+				t.Errorf("Expecting application to terminate with %d, got %d.", tc.code, r)
+			}
+			continue
+		}
+		if strings.Join(execArgv, " ") != "go version" {
+			t.Errorf("Expecting command `go version` to run, got `%v`", execArgv)
+		}
+		b, _ := ioutil.ReadFile("foo.out")
+		if string(b) != "Hello World!" {
+			t.Errorf("Expecting foo.out to be rendered before the command, got `%s`", b)
+		}
+	}
+}
+
+func TestInvokeWithHelpFlagAndJSONFormatListsFuncs(t *testing.T) {
+	r, o, e := run(t, []string{}, []string{"me", "-h", "-format", "json"}, nil)
+	if r != exitOk {
+		t.Errorf(
+			"Expecting application to terminate with ExitOk, %d, got %d.",
+			exitOk,
+			r,
+		)
+	}
+	if e.Len() != 0 {
+		t.Errorf("Expecting stderr len to be 0, got %d", e.Len())
+	}
+	var docs []envtmpl.FuncDoc
+	if err := json.Unmarshal(o.Bytes(), &docs); err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != len(envtmpl.Funcs()) {
+		t.Errorf("Expecting %d funcs, got %d", len(envtmpl.Funcs()), len(docs))
+	}
+	for _, d := range docs {
+		if d.Name == "hash" {
+			ex := "hash(...string) (string, error)"
+			if d.Signature != ex || !d.Variadic || !d.Errors {
+				t.Errorf("Expecting hash signature `%s`, got %+v", ex, d)
+			}
+		}
+		if len(d.Examples) == 0 {
+			t.Errorf("Expecting %s to have examples", d.Name)
+		}
+	}
+}
+
+func TestInvokeWithHelpFlagAndManFormat(t *testing.T) {
+	r, o, _ := run(t, []string{}, []string{"me", "-h", "-format", "man"}, nil)
+	if r != exitOk {
+		t.Errorf(
+			"Expecting application to terminate with ExitOk, %d, got %d.",
+			exitOk,
+			r,
+		)
+	}
+	for _, ex := range []string{".TH ME 1", "\n.SH FUNCTIONS\n", "\n.B hash(...string) (string, error)\n"} {
+		if !strings.Contains(o.String(), ex) {
+			t.Errorf("Expecting stdout to contain `%s`", ex)
+		}
+	}
+}
+
+func TestInvokeWithHelpFlagAndFuncNameDisplaysMatchingFuncs(t *testing.T) {
+	for q, ex := range map[string][]string{
+		"hash":   {"hash"},
+		"URL":    {"url"},
+		"trim":   {"trimPrefix", "trimSpace", "trimSuffix"},
+		"json":   {"jsonDecode", "jsonEncode"},
+		"slugfy": {"slugify"},
+	} {
+		r, o, e := run(t, []string{}, []string{"me", "-h", "-format", "json", q}, nil)
+		if r != exitOk {
+			t.Errorf(
+				"Expecting application to terminate with ExitOk, %d, got %d.",
+				exitOk,
+				r,
+			)
+		}
+		if e.Len() != 0 {
+			t.Errorf("Expecting stderr len to be 0, got %d", e.Len())
+		}
+		var docs []envtmpl.FuncDoc
+		if err := json.Unmarshal(o.Bytes(), &docs); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, d := range docs {
+			names = append(names, d.Name)
+		}
+		if strings.Join(names, " ") != strings.Join(ex, " ") {
+			t.Errorf("Expecting `%s` to match %v, got %v", q, ex, names)
+		}
+	}
+}
+
+func TestInvokeWithHelpFlagAndUnknownFuncName(t *testing.T) {
+	r, o, e := run(t, []string{}, []string{"me", "-h", "zzzzzz"}, nil)
+	if r != exitUsage {
+		t.Errorf(
+			"Expecting application to terminate with ExitUsage, %d, got %d.",
+			exitUsage,
+			r,
+		)
+	}
+	if o.Len() != 0 {
+		t.Errorf("Expecting stdout len to be 0, got %d", o.Len())
+	}
+	ex := []byte("No function matching 'zzzzzz'.")
+	if !bytes.Equal(bytes.TrimSpace(e.Bytes()), ex) {
+		t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.Bytes())
+	}
+}
+
+func TestInvokeWithTestComparesGoldenFixtures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envtmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "foo.tmpl"), []byte("Hello {{.WHAT}}!\n{{range .Data.h}}{{.}}\n{{end}}"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "hosts.json"), []byte(`["a", "b"]`), 0644)
+	ioutil.WriteFile(
+		filepath.Join(dir, "foo.tmpl.golden"),
+		[]byte("-- env --\nWHAT=World\n-- data --\nh=hosts.json\n-- output --\nHello World!\na\nb\n"),
+		0644,
+	)
+	bad := filepath.Join(dir, "foo.tmpl.bad.golden")
+	ioutil.WriteFile(bad, []byte("A comment.\n-- env --\nWHAT=You\n-- output --\nHello World!\n"), 0644)
+	r, o, _ := run(t, []string{"WHAT=Env"}, []string{"me", "-test", dir}, nil)
+	if r != exitTestFailure {
+		t.Errorf(
+			"Expecting application to terminate with ExitTestFailure, %d, got %d.",
+			exitTestFailure,
+			r,
+		)
+	}
+	ex := "--- FAIL: " + bad + "\n" +
+		"--- " + bad + "\n" +
+		"+++ foo.tmpl\n" +
+		"@@ -1,1 +1,1 @@\n" +
+		"-Hello World!\n" +
+		"+Hello You!\n" +
+		"FAIL 1 of 2 fixtures failed.\n"
+	if o.String() != ex {
+		t.Errorf("Expecting stdout to equal `%s` got `%s`", ex, o.String())
+	}
+
+	r, o, _ = run(t, []string{}, []string{"me", "-test", "-update", dir}, nil)
+	if r != exitOk {
+		t.Errorf("Expecting application to terminate with ExitOk, %d, got %d.", exitOk, r)
+	}
+	ex = "Updated " + bad + "\nok 2 fixtures passed.\n"
+	if o.String() != ex {
+		t.Errorf("Expecting stdout to equal `%s` got `%s`", ex, o.String())
+	}
+	b, _ := ioutil.ReadFile(bad)
+	ex = "A comment.\n-- env --\nWHAT=You\n-- output --\nHello You!\n"
+	if string(b) != ex {
+		t.Errorf("Expecting %s to equal `%s` got `%s`", bad, ex, b)
+	}
+}
+
+func TestInvokeWithTestAppliesFrontMatterAndContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envtmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "db.tmpl"), []byte(
+		"---\nformat: json\nrequired:\n  PORT:\n    default: \"5432\"\n---\n"+
+			"{\"port\": {{.PORT}}, \"template\": \"{{.Meta.Template}}\"}\n",
+	), 0644)
+	ioutil.WriteFile(
+		filepath.Join(dir, "db.tmpl.golden"),
+		[]byte("-- output --\n{\"port\": 5432, \"template\": \"db.tmpl\"}\n"),
+		0644,
+	)
+	ioutil.WriteFile(
+		filepath.Join(dir, "db.tmpl.invalid.golden"),
+		[]byte("-- env --\nPORT=x\n-- output --\n{\"port\": x, \"template\": \"db.tmpl\"}\n"),
+		0644,
+	)
+	r, o, _ := run(t, []string{}, []string{"me", "-front-matter", "-context", "-test", dir}, nil)
+	if r != exitTestFailure {
+		t.Errorf(
+			"Expecting application to terminate with ExitTestFailure, %d, got %d.",
+			exitTestFailure,
+			r,
+		)
+	}
+	invalid := filepath.Join(dir, "db.tmpl.invalid.golden")
+	ex := "--- FAIL: " + invalid + "\n" +
+		"Template execution: " + filepath.Join(dir, "db.tmpl") + ": output is not valid json: " +
+		"invalid character 'x' looking for beginning of value\n" +
+		"FAIL 1 of 2 fixtures failed.\n"
+	if o.String() != ex {
+		t.Errorf("Expecting stdout to equal `%s` got `%s`", ex, o.String())
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15")
+	b := []byte("1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n")
+	ex := "--- a\n+++ b\n" +
+		"@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n" +
+		"@@ -12,4 +12,4 @@\n 12\n 13\n 14\n-15\n\\ No newline at end of file\n+15\n"
+	if d := unifiedDiff("a", "b", a, b); d != ex {
+		t.Errorf("Expecting diff to equal `%s` got `%s`", ex, d)
+	}
+	if d := unifiedDiff("a", "b", a, a); d != "" {
+		t.Errorf("Expecting no diff got `%s`", d)
+	}
+	var big, changed bytes.Buffer
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&big, "line %d\n", i)
+		if i%100 == 50 {
+			fmt.Fprintf(&changed, "changed %d\n", i)
+		} else {
+			fmt.Fprintf(&changed, "line %d\n", i)
+		}
+	}
+	d := unifiedDiff("a", "b", big.Bytes(), changed.Bytes())
+	if n := strings.Count(d, "\n@@ "); n != 200 {
+		t.Errorf("Expecting 200 hunks for a large diff got %d", n)
+	}
+	if !strings.Contains(d, "@@ -48,7 +48,7 @@\n line 47\n line 48\n line 49\n-line 50\n+changed 50\n") {
+		t.Errorf("Unexpected large diff `%s`", d[:200])
+	}
+	big.Reset()
+	changed.Reset()
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(&big, "old %d\n", i)
+		fmt.Fprintf(&changed, "new %d\n", i)
+	}
+	d = unifiedDiff("a", "b", big.Bytes(), changed.Bytes())
+	if !strings.HasPrefix(d, "--- a\n+++ b\n@@ -1,50000 +1,50000 @@\n") {
+		t.Errorf("Unexpected diff of unrelated files `%s`", d[:200])
+	}
+	if n, m := strings.Count(d, "\n-old "), strings.Count(d, "\n+new "); n != 50000 || m != 50000 {
+		t.Errorf("Expecting 50000 lines removed and added got %d and %d", n, m)
+	}
+}
+
+func TestInvokeWithCheckReportsProblems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envtmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "a.tmpl"), []byte("ok\n{{ if .A }}"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b.tmpl"), []byte(
+		"{{ template \"part\" }}{{ template \"nope\" }}\n"+
+			"{{ \"x\" | upper }}{{ upper \"x\" \"y\" }}\n"+
+			"{{ include \"nope\" . }}{{ hash }}{{ regexReplace \"(\" \"\" \"x\" }}\n",
+	), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "c.tmpl"), []byte(`{{ define "part" }}{{ .A | regexReplace "a+" "b" }}{{ end }}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "d.tmpl"), []byte(`{{ nofunc }}`), 0644)
+	r, o, _ := run(t, []string{}, []string{"me", "-check", dir}, nil)
+	if r != exitTemplateParseError {
+		t.Errorf(
+			"Expecting application to terminate with ExitTemplateParseError, %d, got %d.",
+			exitTemplateParseError,
+			r,
+		)
+	}
+	a, b, d := filepath.Join(dir, "a.tmpl"), filepath.Join(dir, "b.tmpl"), filepath.Join(dir, "d.tmpl")
+	ex := a + ":2: unexpected EOF\n" +
+		b + ":1:34: no such template \"nope\"\n" +
+		b + ":2:21: wrong number of args for upper: want 1 got 2\n" +
+		b + ":3:12: no such template \"nope\"\n" +
+		b + ":3:49: regexReplace: error parsing regexp: missing closing ): `(`\n" +
+		d + ":1: function \"nofunc\" not defined\n"
+	if o.String() != ex {
+		t.Errorf("Expecting stdout to equal `%s` got `%s`", ex, o.String())
+	}
+
+	os.Remove(a)
+	os.Remove(b)
+	os.Remove(d)
+	r, o, _ = run(t, []string{}, []string{"me", "-check", "-format", "json", dir}, nil)
+	if r != exitOk {
+		t.Errorf("Expecting application to terminate with ExitOk, %d, got %d.", exitOk, r)
+	}
+	if o.String() != "[]\n" {
+		t.Errorf("Expecting stdout to equal `[]` got `%s`", o.String())
+	}
+}
+
+func TestInvokeWithErrorFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envtmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "a.tmpl"), []byte("A\n  {{ template \"b.tmpl\" . }}"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b.tmpl"), []byte(`{{ include "c.tmpl" . }}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "c.tmpl"), []byte("C\n\t{{ .WHAT }}"), 0644)
+	a, b, c := filepath.Join(dir, "a.tmpl"), filepath.Join(dir, "b.tmpl"), filepath.Join(dir, "c.tmpl")
+
+	r, _, e := run(t, []string{}, []string{"me", "-strict", "-error-format", "pretty", dir, "a.tmpl"}, nil)
+	if r != exitMissingVariable {
+		t.Errorf(
+			"Expecting application to terminate with ExitMissingVariable, %d, got %d.",
+			exitMissingVariable,
+			r,
+		)
+	}
+	ex := "Missing environment variable: WHAT (" + c + ":2:4)\n" +
+		"  --> " + c + ":2:5\n" +
+		"  2 | \t{{ .WHAT }}\n" +
+		"    | \t   ^\n" +
+		"  called from " + b + ":1:4 include \"c.tmpl\" .\n" +
+		"  called from " + a + ":2:15 {{template \"b.tmpl\" .}}\n"
+	if e.String() != ex {
+		t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.String())
+	}
+
+	r, _, e = run(t, []string{}, []string{"me", "-strict", "-error-format", "json", dir, "a.tmpl"}, nil)
+	if r != exitMissingVariable {
+		t.Errorf(
+			"Expecting application to terminate with ExitMissingVariable, %d, got %d.",
+			exitMissingVariable,
+			r,
+		)
+	}
+	var d diagnostic
+	if err := json.Unmarshal(e.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Kind != "missing-variable" || d.File != c || d.Line != 2 || d.Column != 5 || len(d.Stack) != 2 {
+		t.Errorf("Unexpected diagnostic %+v", d)
+	}
+
+	ioutil.WriteFile(c, []byte("C\n{{ .WHAT"), 0644)
+	r, _, e = run(t, []string{}, []string{"me", "-error-format", "pretty", dir, "a.tmpl"}, nil)
+	if r != exitTemplateParseError {
+		t.Errorf(
+			"Expecting application to terminate with ExitTemplateParseError, %d, got %d.",
+			exitTemplateParseError,
+			r,
+		)
+	}
+	ex = "Template parse error: template: c.tmpl:2: unclosed action\n" +
+		"  --> " + c + ":2\n" +
+		"  2 | {{ .WHAT\n"
+	if e.String() != ex {
+		t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.String())
+	}
+}
+
+func TestInvokeWithDiffDisplaysChangesWithoutWriting(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte("a\nHello {{.WHAT}}!\nc\n"), 0644)
+	defer os.Remove("foo.tmpl")
+	ioutil.WriteFile("foo.out", []byte("a\nHello World!\nc\n"), 0600)
+	defer os.Remove("foo.out")
+	r, o, e := run(t, []string{"WHAT=World"}, []string{"me", "-diff", "foo.out", ".", "foo.tmpl"}, nil)
+	if r != exitOk || o.Len() != 0 || e.Len() != 0 {
+		t.Errorf("Expecting no differences, got %d `%s` `%s`", r, o.Bytes(), e.Bytes())
+	}
+	r, o, _ = run(t, []string{"WHAT=You"}, []string{"me", "-diff", "foo.out", ".", "foo.tmpl"}, nil)
+	if r != exitDiff {
+		t.Errorf(
+			"Expecting application to terminate with ExitDiff, %d, got %d.",
+			exitDiff,
+			r,
+		)
+	}
+	ex := "--- foo.out\n+++ foo.out (rendered)\n@@ -1,3 +1,3 @@\n a\n-Hello World!\n+Hello You!\n c\n"
+	if o.String() != ex {
+		t.Errorf("Expecting stdout to equal `%s` got `%s`", ex, o.String())
+	}
+	b, _ := ioutil.ReadFile("foo.out")
+	if string(b) != "a\nHello World!\nc\n" {
+		t.Errorf("Expecting foo.out to be untouched, got `%s`", b)
+	}
+	r, _, _ = run(t, []string{}, []string{"me", "-diff", "foo.out", "-o", "foo.out", ".", "foo.tmpl"}, nil)
+	if r != exitUsage {
+		t.Errorf("Expecting application to terminate with ExitUsage, %d, got %d.", exitUsage, r)
+	}
+}
+
+func TestInvokeWithRecursiveMirrorsTree(t *testing.T) {
+	src, err := ioutil.TempDir("", "envtmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	dest := filepath.Join(src, "out")
+	os.MkdirAll(filepath.Join(src, "nginx", "sites"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "nginx", "_partial.tmpl"), []byte(`{{define "server"}}server {{.HOST}};{{end}}`), 0644)
+	ioutil.WriteFile(filepath.Join(src, "nginx", "sites", "a.conf.tmpl"), []byte(`{{template "server" .}}`), 0600)
+	ioutil.WriteFile(filepath.Join(src, "nginx", "mime.types"), []byte("text/html html;\n"), 0640)
+	ioutil.WriteFile(filepath.Join(src, "run.sh.tmpl"), []byte(`{{ include "nginx/sites/a.conf.tmpl" . }}`), 0755)
+	r, o, e := run(t, []string{"HOST=example.com"}, []string{"me", "-r", src, dest}, nil)
+	if r != exitOk {
+		t.Errorf("Expecting application to terminate with ExitOk, %d, got %d.", exitOk, r)
+		t.Error(e)
+	}
+	if o.Len() != 0 {
+		t.Errorf("Expecting stdout len to be 0, got %d", o.Len())
+	}
+	for _, f := range []struct {
+		path    string
+		content string
+		mode    os.FileMode
+	}{
+		{"nginx/sites/a.conf", "server example.com;", 0600},
+		{"nginx/mime.types", "text/html html;\n", 0640},
+		{"run.sh", "server example.com;", 0755},
+	} {
+		p := filepath.Join(dest, filepath.FromSlash(f.path))
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if fi.Mode().Perm() != f.mode {
+			t.Errorf("Expecting %s to have mode %o got %o", p, f.mode, fi.Mode().Perm())
+		}
+		if b, _ := ioutil.ReadFile(p); string(b) != f.content {
+			t.Errorf("Expecting %s to equal `%s` got `%s`", p, f.content, b)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, "nginx", "_partial")); !os.IsNotExist(err) {
+		t.Errorf("Expecting partials not to be rendered, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "out")); !os.IsNotExist(err) {
+		t.Errorf("Expecting destDir not to be walked, got %v", err)
+	}
+	r, _, e = run(t, []string{"HOST=example.com"}, []string{"me", "-r", "-mode", "0604", src, dest}, nil)
+	if r != exitOk {
+		t.Errorf("Expecting application to terminate with ExitOk, %d, got %d.", exitOk, r)
+		t.Error(e)
+	}
+	for _, f := range []string{"nginx/sites/a.conf", "nginx/mime.types", "run.sh"} {
+		p := filepath.Join(dest, filepath.FromSlash(f))
+		if fi, err := os.Stat(p); err != nil || fi.Mode().Perm() != 0604 {
+			t.Errorf("Expecting %s to have mode 604 with -mode, got %v %v", p, fi, err)
+		}
+	}
+}
+
+func TestInvokeWithPatternsAndIncludeDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envtmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	shared := filepath.Join(dir, "shared")
+	os.Mkdir(shared, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "app.tpl"), []byte(`{{ template "header.gotmpl" . }} {{ include "footer" . }}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "ignored.txt"), []byte(`{{ .Broken`), 0644)
+	ioutil.WriteFile(filepath.Join(shared, "header.gotmpl"), []byte(`Hello {{.WHAT}}`), 0644)
+	ioutil.WriteFile(filepath.Join(shared, "footer.tpl"), []byte(`{{ define "footer" }}Bye{{ end }}`), 0644)
+	args := []string{"me", "-pattern", "*.tpl", "-pattern", "*.gotmpl", "-I", shared, dir, "app.tpl"}
+	r, o, e := run(t, []string{"WHAT=World"}, args, nil)
+	if r != exitOk {
+		t.Errorf("Expecting application to terminate with ExitOk, %d, got %d.", exitOk, r)
+		t.Error(e)
+	}
+	if o.String() != "Hello World Bye" {
+		t.Errorf("Expecting stdout to equal `Hello World Bye` got `%s`", o.String())
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "footer.tpl"), []byte(`local`), 0644)
+	r, _, e = run(t, []string{}, args, nil)
+	if r != exitTemplateParseError {
+		t.Errorf(
+			"Expecting application to terminate with ExitTemplateParseError, %d, got %d.",
+			exitTemplateParseError,
+			r,
+		)
+	}
+	ex := "Template parse error: template: " + filepath.Join(dir, "footer.tpl") +
+		": \"footer.tpl\" is already defined by " + filepath.Join(shared, "footer.tpl")
+	if strings.TrimSpace(e.String()) != ex {
+		t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.String())
+	}
+	os.Remove(filepath.Join(dir, "footer.tpl"))
+
+	other := filepath.Join(dir, "other")
+	os.Mkdir(other, 0755)
+	ioutil.WriteFile(filepath.Join(other, "bye.tpl"), []byte(`{{ define "footer" }}Later{{ end }}`), 0644)
+	args = []string{"me", "-pattern", "*.tpl", "-pattern", "*.gotmpl", "-I", shared, "-I", other, dir, "app.tpl"}
+	r, _, e = run(t, []string{}, args, nil)
+	if r != exitTemplateParseError {
+		t.Errorf(
+			"Expecting application to terminate with ExitTemplateParseError, %d, got %d.",
+			exitTemplateParseError,
+			r,
+		)
+	}
+	ex = "Template parse error: template: " + filepath.Join(other, "bye.tpl") +
+		": \"footer\" is already defined by " + filepath.Join(shared, "footer.tpl")
+	if strings.TrimSpace(e.String()) != ex {
+		t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.String())
+	}
+}
+
+func TestInvokeOverridingBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envtmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	shared := filepath.Join(dir, "shared")
+	os.Mkdir(shared, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "a_base.tmpl"), []byte(`{{ define "base" }}<{{ block "content" . }}default{{ end }}>{{ end }}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "page.tmpl"), []byte(`{{ define "content" }}page{{ end }}{{ template "base" . }}`), 0644)
+	r, o, e := run(t, []string{}, []string{"me", dir, "page.tmpl"}, nil)
+	if r != exitOk {
+		t.Errorf("Expecting application to terminate with ExitOk, %d, got %d.", exitOk, r)
+		t.Error(e)
+	}
+	if o.String() != "<page>" {
+		t.Errorf("Expecting stdout to equal `<page>` got `%s`", o.String())
+	}
+
+	ioutil.WriteFile(filepath.Join(shared, "layout.tmpl"), []byte(`{{ define "layout" }}[{{ block "body" . }}shared{{ end }}]{{ end }}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "page.tmpl"), []byte(`{{ define "body" }}page{{ end }}{{ template "layout" . }}`), 0644)
+	r, o, e = run(t, []string{}, []string{"me", "-I", shared, dir, "page.tmpl"}, nil)
+	if r != exitOk {
+		t.Errorf("Expecting application to terminate with ExitOk, %d, got %d.", exitOk, r)
+		t.Error(e)
+	}
+	if o.String() != "[page]" {
+		t.Errorf("Expecting stdout to equal `[page]` got `%s`", o.String())
+	}
+}
+
+func TestInvokeWithFrontMatter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envtmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "app.json")
+	ioutil.WriteFile(filepath.Join(dir, "app.tmpl"), []byte(
+		"---\n"+
+			"output: "+out+"\n"+
+			"mode: \"0600\"\n"+
+			"delims: [\"[[\", \"]]\"]\n"+
+			"format: json\n"+
+			"required:\n"+
+			"  HOST:\n"+
+			"    description: The host name.\n"+
+			"  PORT:\n"+
+			"    default: \"80\"\n"+
+			"---\n"+
+			"{\"url\": \"[[ .HOST ]]:[[ .PORT ]]\"[[ with .EXTRA ]][[ . ]][[ end ]]}\n",
+	), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "plain.tmpl"), []byte("+++\n+++\n{{ .HOST }}"), 0644)
+
+	r, o, e := run(t, []string{"HOST=example.com"}, []string{"me", "-front-matter", dir, "app.tmpl"}, nil)
+	if r != exitOk {
+		t.Errorf("Expecting application to terminate with ExitOk, %d, got %d.", exitOk, r)
+		t.Error(e)
+	}
+	if o.Len() != 0 {
+		t.Errorf("Expecting stdout len to be 0, got %d", o.Len())
+	}
+	b, _ := ioutil.ReadFile(out)
+	if string(b) != "{\"url\": \"example.com:80\"}\n" {
+		t.Errorf("Expecting %s to equal `{\"url\": \"example.com:80\"}` got `%s`", out, b)
+	}
+	if fi, err := os.Stat(out); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Expecting %s to have mode 0600, got %v %v", out, fi, err)
+	}
+
+	r, _, e = run(t, []string{}, []string{"me", "-front-matter", dir, "app.tmpl"}, nil)
+	if r != exitMissingVariable {
+		t.Errorf(
+			"Expecting application to terminate with ExitMissingVariable, %d, got %d.",
+			exitMissingVariable,
+			r,
+		)
+	}
+	ex := "Missing environment variable: HOST - The host name. (" + filepath.Join(dir, "app.tmpl") + ")"
+	if strings.TrimSpace(e.String()) != ex {
+		t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.String())
+	}
+
+	r, _, _ = run(t, []string{"HOST=h", "EXTRA=,"}, []string{"me", "-front-matter", dir, "app.tmpl"}, nil)
+	if r != exitTemplateExecutionError {
+		t.Errorf(
+			"Expecting application to terminate with ExitTemplateExecutionError, %d, got %d.",
+			exitTemplateExecutionError,
+			r,
+		)
+	}
+	if b, _ := ioutil.ReadFile(out); string(b) != "{\"url\": \"example.com:80\"}\n" {
+		t.Errorf("Expecting invalid output not to be written, got `%s`", b)
+	}
+
+	r, o, _ = run(t, []string{"HOST=h"}, []string{"me", "-front-matter", dir, "plain.tmpl"}, nil)
+	if r != exitOk || o.String() != "h" {
+		t.Errorf("Expecting `h` and ExitOk, got `%s` and %d", o.String(), r)
+	}
+	r, o, _ = run(t, []string{"HOST=h"}, []string{"me", dir, "plain.tmpl"}, nil)
+	if r != exitOk || o.String() != "+++\n+++\nh" {
+		t.Errorf("Expecting front matter to be kept without -front-matter, got `%s` and %d", o.String(), r)
+	}
+
+	other := filepath.Join(dir, "other.json")
+	ioutil.WriteFile(other, []byte("{\"url\": \"example.com:80\"}\n"), 0644)
+	r, o, e = run(t, []string{"HOST=h"}, []string{"me", "-front-matter", "-diff", other, dir, "app.tmpl"}, nil)
+	if r != exitDiff {
+		t.Errorf("Expecting application to terminate with ExitDiff, %d, got %d.", exitDiff, r)
+		t.Error(e)
+	}
+	if !strings.HasPrefix(o.String(), "--- "+other+"\n") {
+		t.Errorf("Expecting -diff to compare with %s rather than the front matter output, got `%s`", other, o.String())
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "bad.tmpl"), []byte("---\nouptut: x\n---\n"), 0644)
+	r, _, e = run(t, []string{}, []string{"me", "-front-matter", dir, "plain.tmpl"}, nil)
+	if r != exitTemplateParseError {
+		t.Errorf(
+			"Expecting application to terminate with ExitTemplateParseError, %d, got %d.",
+			exitTemplateParseError,
+			r,
+		)
+	}
+	ex = "Template parse error: template: bad.tmpl:1: front matter: yaml: unmarshal errors:\n  line 1: field ouptut not found in type main.frontMatter"
+	if strings.TrimSpace(e.String()) != ex {
+		t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.String())
+	}
+}
+
+func TestNestVars(t *testing.T) {
+	nested, err := nestVars(map[string]string{
+		"FLAT":                    "f",
+		"APP__DB__HOST":           "db",
+		"APP__UPSTREAMS__0__URL":  "http://a",
+		"APP__UPSTREAMS__1__URL":  "http://b",
+		"APP__PORTS__0":           "80",
+		"__CF_USER_TEXT_ENCODING": "x",
+		"APP____DB":               "empty",
+		"FOO__":                   "trailing",
+	}, "__")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(nested)
+	ex := `{"APP":{"DB":{"HOST":"db"},"PORTS":["80"],"UPSTREAMS":[{"URL":"http://a"},{"URL":"http://b"}]}}`
+	if string(b) != ex {
+		t.Errorf("Expecting `%s` got `%s`", ex, b)
+	}
+	for vars, ex := range map[string]string{
+		"APP=1,APP__DB=2":           "APP is both a value and the parent of APP__DB",
+		"APP__DB=1,APP__DB__HOST=2": "APP__DB is both a value and the parent of APP__DB__HOST",
+		"APP__0=1,APP__2=2":         "APP is missing index 1",
+	} {
+		m := make(map[string]string)
+		for _, kv := range strings.Split(vars, ",") {
+			p := strings.SplitN(kv, "=", 2)
+			m[p[0]] = p[1]
+		}
+		if _, err := nestVars(m, "__"); err == nil || err.Error() != ex {
+			t.Errorf("Expecting error `%s` for %s got `%v`", ex, vars, err)
+		}
+	}
+}
+
+func TestInvokeWithNest(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte(`{{.APP.DB.HOST}}{{range .APP.UPSTREAMS}} {{.URL}}{{end}} {{.APP__DB__HOST}}`), 0644)
+	defer os.Remove("foo.tmpl")
+	env := []string{"APP__DB__HOST=db", "APP__UPSTREAMS__0__URL=a", "APP__UPSTREAMS__1__URL=b"}
+	r, o, e := run(t, env, []string{"me", "-nest", "__", ".", "foo.tmpl"}, nil)
+	if r != exitOk {
+		t.Errorf("Expecting application to terminate with ExitOk, %d, got %d.", exitOk, r)
+		t.Error(e)
+	}
+	if o.String() != "db a b db" {
+		t.Errorf("Expecting stdout to equal `db a b db` got `%s`", o.String())
+	}
+	r, _, e = run(t, append(env, "APP=x"), []string{"me", "-nest", "__", ".", "foo.tmpl"}, nil)
+	if r != exitDataError {
+		t.Errorf("Expecting application to terminate with ExitDataError, %d, got %d.", exitDataError, r)
+	}
+	ex := "Data error: APP is both a value and the parent of APP__DB__HOST"
+	if strings.TrimSpace(e.String()) != ex {
+		t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.String())
+	}
+}
+
+func TestInvokeWithEnvFilters(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte(`{{.APP_HOST}} {{.APP_TOKEN}} {{.CI_TOKEN}} {{.HOME}}`), 0644)
+	defer os.Remove("foo.tmpl")
+	env := []string{"APP_HOST=h", "APP_TOKEN=t", "CI_TOKEN=c", "HOME=/root"}
+	for _, c := range []struct {
+		args   []string
+		stdout string
+		stderr string
+	}{
+		{[]string{"-deny", "*_TOKEN"}, "h <no value> <no value> /root", ""},
+		{[]string{"-allow", "APP_*", "-deny", "/TOKEN$/"}, "h <no value> <no value> <no value>", ""},
+		{
+			[]string{"-i", "-v"},
+			"<no value> <no value> <no value> <no value>",
+			"Filtered environment variable: APP_HOST (-i)\n" +
+				"Filtered environment variable: APP_TOKEN (-i)\n" +
+				"Filtered environment variable: CI_TOKEN (-i)\n" +
+				"Filtered environment variable: HOME (-i)\n",
+		},
+		{
+			[]string{"-i", "-allow", "/^(APP|CI)_/", "-deny", "CI_*", "-v"},
+			"h t <no value> <no value>",
+			"Filtered environment variable: CI_TOKEN (denied by CI_*)\n" +
+				"Filtered environment variable: HOME (not allowed)\n",
+		},
+	} {
+		args := append(append([]string{"me"}, c.args...), ".", "foo.tmpl")
+		r, o, e := run(t, env, args, nil)
+		if r != exitOk {
+			t.Errorf("Expecting %v to terminate with ExitOk, %d, got %d.", c.args, exitOk, r)
+		}
+		if o.String() != c.stdout {
+			t.Errorf("Expecting stdout for %v to equal `%s` got `%s`", c.args, c.stdout, o.String())
+		}
+		if e.String() != c.stderr {
+			t.Errorf("Expecting stderr for %v to equal `%s` got `%s`", c.args, c.stderr, e.String())
+		}
+	}
+	r, _, e := run(t, env, []string{"me", "-allow", "/(/", ".", "foo.tmpl"}, nil)
+	if r != exitUsage {
+		t.Errorf("Expecting application to terminate with ExitUsage, %d, got %d.", exitUsage, r)
+	}
+	ex := "Invalid pattern: /(/: error parsing regexp: missing closing ): `(`"
+	if strings.TrimSpace(e.String()) != ex {
+		t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.String())
+	}
+	app := new([]string{"NO_COLOR=1"}, []string{"me", "-i"}, nil, nil, nil)
+	if _, ok := app.runtime().LookupEnv("NO_COLOR"); ok {
+		t.Error("Expecting NO_COLOR to be hidden from templates by -i")
+	}
+	if _, ok := app.lookupEnv("NO_COLOR"); !ok {
+		t.Error("Expecting NO_COLOR to still be seen by envtmpl itself with -i")
+	}
+}
+
+func TestInvokeWithVarOverrides(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte(
+		`{{.NAME}} {{if eq .REPLICAS 3}}three{{end}} {{if .DEBUG}}debug{{end}} {{range .PORTS}}{{.}},{{end}} {{.CFG.ratio}} {{.CERT}}`,
+	), 0644)
+	defer os.Remove("foo.tmpl")
+	ioutil.WriteFile("cert.pem", []byte("CERT\n"), 0644)
+	defer os.Remove("cert.pem")
+	r, o, e := run(t, []string{"NAME=env", "DEBUG="}, []string{
+		"me",
+		"-var", "NAME=first",
+		"-var-json", "REPLICAS=3",
+		"-var-json", "DEBUG=true",
+		"-var-json", "PORTS=[80, 443]",
+		"-var-json", `CFG={"ratio": 0.5}`,
+		"-var-file", "CERT=cert.pem",
+		"-var", "NAME=last",
+		".", "foo.tmpl",
+	}, nil)
+	if r != exitOk {
+		t.Errorf("Expecting application to terminate with ExitOk, %d, got %d.", exitOk, r)
+		t.Error(e)
+	}
+	ex := "last three debug 80,443, 0.5 CERT\n"
+	if o.String() != ex {
+		t.Errorf("Expecting stdout to equal `%s` got `%s`", ex, o.String())
+	}
+	for _, c := range []struct {
+		args   []string
+		stderr string
+	}{
+		{[]string{"-var", "NAME"}, "Data error: -var: expecting KEY=value, got 'NAME'"},
+		{[]string{"-var-json", "NAME={"}, "Data error: -var-json NAME: unexpected EOF"},
+		{[]string{"-var-json", "NAME=1 2"}, "Data error: -var-json NAME: unexpected data after JSON value"},
+		{[]string{"-var-file", "NAME=missing"}, "Data error: -var-file NAME: open missing: no such file or directory"},
+	} {
+		args := append(append([]string{"me"}, c.args...), ".", "foo.tmpl")
+		r, _, e := run(t, []string{}, args, nil)
+		if r != exitDataError {
+			t.Errorf("Expecting %v to terminate with ExitDataError, %d, got %d.", c.args, exitDataError, r)
+		}
+		if strings.TrimSpace(e.String()) != c.stderr {
+			t.Errorf("Expecting stderr to equal `%s` got `%s`", c.stderr, e.String())
+		}
+	}
+}
+
+func TestInvokeWithFileSuffix(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte(`{{.DB_PASSWORD}}|{{.API_KEY}}`), 0644)
+	defer os.Remove("foo.tmpl")
+	ioutil.WriteFile("db.secret", []byte("s3cret\n"), 0644)
+	defer os.Remove("db.secret")
+	ioutil.WriteFile("api.secret", []byte("key\n\n"), 0644)
+	defer os.Remove("api.secret")
+	env := []string{
+		"DB_PASSWORD_FILE=db.secret",
+		"API_KEY_FILE=api.secret",
+		"COMPOSE_FILE=docker-compose.yml:override.yml",
+	}
+	args := []string{"me", "-file-suffix", "_FILE", "-file-var", "DB_*", "-file-var", "/^API_KEY$/", ".", "foo.tmpl"}
+	r, o, e := run(t, env, args, nil)
+	if r != exitOk {
+		t.Errorf("Expecting application to terminate with ExitOk, %d, got %d.", exitOk, r)
+		t.Error(e)
+	}
+	if o.String() != "s3cret|key\n" {
+		t.Errorf("Expecting stdout to equal `s3cret|key\n` got `%s`", o.String())
+	}
+	r, _, e = run(t, append(env, "DB_PASSWORD=x"), args, nil)
+	if r != exitDataError {
+		t.Errorf("Expecting application to terminate with ExitDataError, %d, got %d.", exitDataError, r)
+	}
+	ex := "Data error: both DB_PASSWORD and DB_PASSWORD_FILE are set"
+	if strings.TrimSpace(e.String()) != ex {
+		t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.String())
+	}
+	r, o, e = run(t, append(env, "API_KEY=set"), []string{"me", "-file-suffix", "_FILE", ".", "foo.tmpl"}, nil)
+	if r != exitOk {
+		t.Errorf("Expecting application to terminate with ExitOk, %d, got %d.", exitOk, r)
+		t.Error(e)
+	}
+	if o.String() != "s3cret|set" {
+		t.Errorf("Expecting stdout to equal `s3cret|set` got `%s`", o.String())
+	}
+	r, _, e = run(t, env, []string{"me", "-file-var", "DB_*", ".", "foo.tmpl"}, nil)
+	if r != exitUsage {
+		t.Errorf("Expecting application to terminate with ExitUsage, %d, got %d.", exitUsage, r)
+	}
+	ex = "-file-var requires -file-suffix."
+	if strings.TrimSpace(e.String()) != ex {
+		t.Errorf("Expecting stderr to equal `%s` got `%s`", ex, e.String())
+	}
+}
+
+func TestInvokeWithContext(t *testing.T) {
+	ioutil.WriteFile("foo.tmpl", []byte(
+		`{{.FOO}} {{.Env.FOO}} {{.Args}} {{.Meta.Template}} {{.Meta.TemplateDir}} {{.Meta.Output}} `+
+			`{{.Meta.Version}} {{.Meta.Delims}} {{.Meta.Hostname}} {{.Meta.Time.IsZero}}`,
+	), 0644)
+	defer os.Remove("foo.tmpl")
+	r, o, e := run(t, []string{"FOO=foo"}, []string{"me", "-context", ".", "foo.tmpl", "a", "b"}, nil)
+	if r != exitOk {
+		t.Errorf("Expecting application to terminate with ExitOk, %d, got %d.", exitOk, r)
+		t.Error(e)
+	}
+	host, _ := os.Hostname()
+	ex := fmt.Sprintf("foo foo [a b] foo.tmpl .  %s [{{ }}] %s false", Version, host)
+	if o.String() != ex {
+		t.Errorf("Expecting stdout to equal `%s` got `%s`", ex, o.String())
+	}
+	r, _, _ = run(t, []string{"FOO=foo"}, []string{"me", ".", "foo.tmpl", "a", "b"}, nil)
+	if r != exitUsage {
+		t.Errorf("Expecting application to terminate with ExitUsage, %d, got %d.", exitUsage, r)
+	}
+}
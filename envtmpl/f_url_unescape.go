@@ -1,13 +0,0 @@
-package main
-
-import "net/url"
-
-func init() {
-	funcMap["urlUnescape"] = &tmplFuncStruct{
-		short: "Unescapes a URL query string value.",
-		examples: []string{
-			`{{ "Hello+World%%21" | %s }}`,
-		},
-		fn: url.QueryUnescape,
-	}
-}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/williambailey/go-envtmpl"
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatter holds the settings a template declares in a YAML block fenced
+// by --- lines, or a TOML block fenced by +++ lines, at the top of its file.
+type frontMatter struct {
+	Output   string                 `yaml:"output" toml:"output"`
+	Mode     string                 `yaml:"mode" toml:"mode"`
+	Delims   []string               `yaml:"delims" toml:"delims"`
+	Required map[string]requiredVar `yaml:"required" toml:"required"`
+	Format   string                 `yaml:"format" toml:"format"`
+
+	file string
+	perm os.FileMode
+}
+
+// requiredVar is an environment variable that a template needs. A variable
+// with a default is set to it when missing.
+type requiredVar struct {
+	Description string  `yaml:"description" toml:"description"`
+	Default     *string `yaml:"default" toml:"default"`
+}
+
+// splitFrontMatter splits the front matter from the template text b. The
+// front matter is replaced by a template comment spanning the same number of
+// lines, so that line numbers in errors still match the file.
+func splitFrontMatter(file string, b []byte, left, right string) (*frontMatter, string, error) {
+	var fence string
+	switch {
+	case bytes.HasPrefix(b, []byte("---\n")), bytes.HasPrefix(b, []byte("---\r\n")):
+		fence = "---"
+	case bytes.HasPrefix(b, []byte("+++\n")), bytes.HasPrefix(b, []byte("+++\r\n")):
+		fence = "+++"
+	default:
+		return nil, string(b), nil
+	}
+	lines := splitLines(b)
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimRight(lines[i], "\r\n") == fence {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, "", errors.New("front matter is not closed by " + fence)
+	}
+	block := []byte(strings.Join(lines[1:end], ""))
+	fm := &frontMatter{file: file}
+	if fence == "---" {
+		d := yaml.NewDecoder(bytes.NewReader(block))
+		d.KnownFields(true)
+		if err := d.Decode(fm); err != nil && len(bytes.TrimSpace(block)) > 0 {
+			return nil, "", err
+		}
+	} else {
+		md, err := toml.Decode(string(block), fm)
+		if err != nil {
+			return nil, "", err
+		}
+		if u := md.Undecoded(); len(u) > 0 {
+			return nil, "", fmt.Errorf("unknown field %q", u[0].String())
+		}
+	}
+	if err := fm.validate(); err != nil {
+		return nil, "", err
+	}
+	if len(fm.Delims) == 2 {
+		left, right = fm.Delims[0], fm.Delims[1]
+	}
+	text := left + "/*" + strings.Repeat("\n", end+1) + "*/" + right + strings.Join(lines[end+1:], "")
+	return fm, text, nil
+}
+
+func (fm *frontMatter) validate() error {
+	if fm.Mode != "" {
+		m, err := strconv.ParseUint(fm.Mode, 8, 32)
+		if err != nil || os.FileMode(m)&^os.ModePerm != 0 {
+			return fmt.Errorf("invalid file mode '%s'", fm.Mode)
+		}
+		fm.perm = os.FileMode(m)
+	}
+	if fm.Delims != nil && (len(fm.Delims) != 2 || fm.Delims[0] == "" || fm.Delims[1] == "") {
+		return errors.New("delims must be a left and right delimiter")
+	}
+	if _, ok := dataDecoders[fm.Format]; fm.Format != "" && !ok {
+		return fmt.Errorf("unknown format '%s'", fm.Format)
+	}
+	return nil
+}
+
+// source reads a template, splitting off its front matter with -front-matter.
+func (app *cli) source(name, file string, b []byte) (envtmpl.Source, error) {
+	src := envtmpl.Source{Name: name, File: file, Text: string(b)}
+	if !*app.flagFrontMatter {
+		return src, nil
+	}
+	fm, text, err := splitFrontMatter(file, b, *app.flagDelimLeft, *app.flagDelimRight)
+	if err != nil {
+		return src, fmt.Errorf("template: %s:1: front matter: %s", name, err)
+	}
+	src.Text = text
+	if fm != nil {
+		if len(fm.Delims) == 2 {
+			src.LeftDelim, src.RightDelim = fm.Delims[0], fm.Delims[1]
+		}
+		app.frontMatter[name] = fm
+	}
+	return src, nil
+}
+
+// parseFiles reads and parses files, naming each template after the base
+// name of its file. Files from an -I directory are grouped by it, so that
+// it is an error for partials from different -I directories to define the
+// same template.
+func (app *cli) parseFiles(r *envtmpl.Renderer, files []string) error {
+	srcs := make([]envtmpl.Source, len(files))
+	for i, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if srcs[i], err = app.source(filepath.Base(file), file, b); err != nil {
+			return err
+		}
+		for _, dir := range app.flagIncludes {
+			if rel, err := filepath.Rel(dir, file); err == nil && !strings.HasPrefix(rel, "..") {
+				srcs[i].Group = dir
+				break
+			}
+		}
+	}
+	return r.ParseSources(srcs...)
+}
+
+// withDefaults returns data with the defaults of any missing required
+// variables set. Required variables without a default that are missing are
+// returned as an error naming them along with their descriptions.
+func (fm *frontMatter) withDefaults(data interface{}) (interface{}, error) {
+	if fm == nil || len(fm.Required) == 0 {
+		return data, nil
+	}
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return data, nil
+	}
+	out := make(map[string]interface{}, len(m)+len(fm.Required))
+	for k, v := range m {
+		out[k] = v
+	}
+	var missing []string
+	for k, v := range fm.Required {
+		if _, ok := out[k]; ok {
+			continue
+		}
+		if v.Default != nil {
+			out[k] = *v.Default
+			continue
+		}
+		if v.Description != "" {
+			k += " - " + v.Description
+		}
+		missing = append(missing, k)
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%s (%s)", strings.Join(missing, ", "), fm.file)
+	}
+	return out, nil
+}
+
+// validateOutput checks the rendered output against the declared format.
+func (fm *frontMatter) validateOutput(b []byte) error {
+	if fm == nil || fm.Format == "" {
+		return nil
+	}
+	if _, err := dataDecoders[fm.Format](b); err != nil {
+		return fmt.Errorf("%s: output is not valid %s: %s", fm.file, fm.Format, err)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// fixtureMarker starts a section of a golden fixture.
+var fixtureMarker = regexp.MustCompile(`^-- (\S+) --\n?$`)
+
+// fixtureSection is a named section of a golden fixture. Line is the line
+// number of the first line of its content.
+type fixtureSection struct {
+	name    string
+	line    int
+	content string
+}
+
+// fixture is a golden file holding the environment and data used to render a
+// template along with the expected output. Anything before the first section
+// is a comment.
+type fixture struct {
+	comment  string
+	sections []fixtureSection
+}
+
+// parseFixture splits a golden fixture into its sections. A section runs
+// until the next section marker, or for the last section, the end of the
+// file, so that the presence of a trailing newline is kept.
+func parseFixture(b []byte) *fixture {
+	f := &fixture{}
+	var content *string
+	content = &f.comment
+	for n, l := range splitLines(b) {
+		if m := fixtureMarker.FindStringSubmatch(l); m != nil {
+			f.sections = append(f.sections, fixtureSection{name: m[1], line: n + 2})
+			content = &f.sections[len(f.sections)-1].content
+			continue
+		}
+		*content += l
+	}
+	return f
+}
+
+// section returns the content of the named section.
+func (f *fixture) section(name string) (string, bool) {
+	for _, s := range f.sections {
+		if s.name == name {
+			return s.content, true
+		}
+	}
+	return "", false
+}
+
+// withOutput returns the fixture with its output section replaced by output.
+// The output is always written as the last section.
+func (f *fixture) withOutput(output []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(f.comment)
+	for _, s := range f.sections {
+		if s.name == "output" {
+			continue
+		}
+		fmt.Fprintf(&b, "-- %s --\n", s.name)
+		b.WriteString(s.content)
+		if s.content != "" && !strings.HasSuffix(s.content, "\n") {
+			b.WriteByte('\n')
+		}
+	}
+	b.WriteString("-- output --\n")
+	b.Write(output)
+	return b.Bytes()
+}
+
+// fixtureTemplate returns the name of the template tested by a fixture file.
+// foo.tmpl.golden tests foo.tmpl, as does foo.tmpl.case.golden, so that a
+// template may have several fixtures.
+func fixtureTemplate(file string, defined func(string) bool) (string, error) {
+	name := strings.TrimSuffix(filepath.Base(file), ".golden")
+	for n := name; n != ""; n = strings.TrimSuffix(n, filepath.Ext(n)) {
+		if defined(n) {
+			return n, nil
+		}
+		if filepath.Ext(n) == "" {
+			break
+		}
+	}
+	return "", fmt.Errorf("no template named '%s'", name)
+}
+
+// test renders the template of each golden fixture in tmplDir and compares
+// it with the expected output. Fixtures are hermetic: templates only see the
+// variables and data given in the fixture.
+func (app *cli) test(tmplDir string) int {
+	files, err := filepath.Glob(filepath.Join(tmplDir, "*.golden"))
+	if err != nil {
+		fmt.Fprintf(app.stderr, "Template parse error: %s\n", err)
+		return exitTemplateParseError
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(app.stdout, "No fixtures found in %s.\n", tmplDir)
+		return exitOk
+	}
+	if _, err := app.parse(tmplDir); err != nil {
+		return app.parseFailure(tmplDir, err)
+	}
+	var failed int
+	for _, file := range files {
+		if !app.testFixture(tmplDir, file) {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(app.stdout, "FAIL %d of %d fixtures failed.\n", failed, len(files))
+		return exitTestFailure
+	}
+	fmt.Fprintf(app.stdout, "ok %d fixtures passed.\n", len(files))
+	return exitOk
+}
+
+// testFixture runs a single fixture, reporting any failure to STDOUT. With
+// -update a mismatched or missing output section is rewritten instead.
+func (app *cli) testFixture(tmplDir, file string) bool {
+	fail := func(format string, a ...interface{}) bool {
+		fmt.Fprintf(app.stdout, "--- FAIL: %s\n", file)
+		fmt.Fprintf(app.stdout, format, a...)
+		return false
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fail("%s\n", err)
+	}
+	f := parseFixture(b)
+
+	// The fixture is rendered by a copy of app whose environment, .env
+	// files and data files come from the fixture.
+	fa := *app
+	fa.stderr = app.stdout
+	fa.env = nil
+	fa.flagEnvFiles = nil
+	fa.flagData = nil
+	fa.overrides = nil
+	vars := make(map[string]string)
+	for _, s := range f.sections {
+		switch s.name {
+		case "env":
+			err := parseEnvFile(
+				file,
+				strings.NewReader(s.content),
+				func(k string) string { return vars[k] },
+				func(k, v string) {
+					vars[k] = v
+					fa.env = append(fa.env, k+"="+v)
+				},
+			)
+			if e, ok := err.(*envFileError); ok {
+				e.line += s.line - 1
+			}
+			if err != nil {
+				return fail("Data error: %s\n", err)
+			}
+		case "data":
+			for _, l := range strings.Split(s.content, "\n") {
+				if l = strings.TrimSpace(l); l == "" {
+					continue
+				}
+				name, format, path, err := parseDataFlag(l)
+				if err != nil {
+					return fail("Data error: %s\n", err)
+				}
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(filepath.Dir(file), path)
+				}
+				if format != "" {
+					name += ":" + format
+				}
+				fa.flagData = append(fa.flagData, name+"="+path)
+			}
+		case "output":
+		default:
+			return fail("Unknown section '%s'.\n", s.name)
+		}
+	}
+
+	r, err := fa.parse(tmplDir)
+	if err != nil {
+		return fail("Template parse error: %s\n", err)
+	}
+	name, err := fixtureTemplate(file, func(n string) bool {
+		return r.Template().Lookup(n) != nil
+	})
+	if err != nil {
+		return fail("%s\n", err)
+	}
+	data, err := fa.data()
+	if err != nil {
+		return fail("Data error: %s\n", err)
+	}
+	_, tdata, err := fa.prepareTarget(&job{tmplDir: tmplDir}, target{name: name}, data)
+	if err != nil {
+		return fail("Missing environment variable: %s\n", err)
+	}
+	var out bytes.Buffer
+	if err := r.Render(&out, name, tdata); err != nil {
+		fmt.Fprintf(app.stdout, "--- FAIL: %s\n", file)
+		fa.executionError(r, tmplDir, name, err)
+		return false
+	}
+	if err := fa.frontMatter[name].validateOutput(out.Bytes()); err != nil {
+		return fail("Template execution: %s\n", err)
+	}
+
+	expected, ok := f.section("output")
+	if ok && expected == out.String() {
+		return true
+	}
+	if *app.flagUpdate {
+		if err := writeFileAtomic(file, f.withOutput(out.Bytes()), 0, -1, -1); err != nil {
+			return fail("Output error: %s\n", err)
+		}
+		fmt.Fprintf(app.stdout, "Updated %s\n", file)
+		return true
+	}
+	if !ok {
+		return fail("No output section, see -update.\n")
+	}
+	return fail("%s", unifiedDiff(file, name, []byte(expected), out.Bytes()))
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/williambailey/go-envtmpl"
+)
+
+const helpTextTemplate = `{{ range .funcs }}{{ .Signature }}
+{{ .Short | wordWrap 76 | linePrefix "    " }}
+
+    Parameters: {{ if .Params }}{{ join .Params ", " }}{{ else }}none{{ end }}
+    Returns:    {{ join .Results ", " }}
+    Can fail:   {{ if .Errors }}yes, an error aborts rendering{{ else }}no{{ end }}
+{{ range .Examples }}
+    Template:
+{{ .Template | linePrefix "        " }}
+    Output:
+{{ .Output | linePrefix "        " }}
+{{ end }}{{ if .Error }}
+    Examples could not be rendered: {{ .Error }}
+{{ end }}
+{{ end }}`
+
+const helpManTemplate = `.TH {{ upper .cmd | man }} 1 "" "{{ .cmd | man }} {{ .version }}"
+.SH NAME
+{{ .cmd | man }} \- render text templates using environment variables
+.SH SYNOPSIS
+.nf
+{{ .usage1 | man }}
+{{ .usage2 | man }}
+{{ .usageStdin | man }}
+{{ .usageBatch | man }}
+{{ .usageCommand | man }}
+.fi
+.SH DESCRIPTION
+Parse tmplDir/*.tmpl and render tmplName.tmpl using environment variables.
+See http://golang.org/pkg/text/template/ for template syntax.
+.SH OPTIONS
+{{ range .flags }}.TP
+.B \-{{ .Name | man }}{{ if and .DefValue (ne .DefValue "false") }} "{{ .DefValue | man }}"{{ end }}
+{{ .Usage | man }}
+{{ end }}.SH EXIT STATUS
+{{ range .exitCodes }}.TP
+.B {{ .Code }}
+{{ .Desc | man }}
+{{ end }}.SH FUNCTIONS
+{{ range .funcs }}.TP
+.B {{ .Signature | man }}
+{{ .Short | man }}
+{{ range .Examples }}.RS
+.PP
+Template:
+.PP
+.nf
+{{ .Template | man }}
+.fi
+.PP
+Output:
+.PP
+.nf
+{{ .Output | man }}
+.fi
+.RE
+{{ end }}{{ end }}`
+
+// help displays help in the format given by -format. If a query is given
+// then only the matching functions are displayed, as text by default.
+func (app *cli) help() int {
+	query := strings.Join(app.flag.Args(), " ")
+	format := *app.flagFormat
+	if query == "" && (format == "" || format == "markdown") {
+		app.helpUsage()
+		return exitUsage
+	}
+	var docs []envtmpl.FuncDoc
+	if query == "" {
+		docs = envtmpl.Docs()
+	} else {
+		funcs := envtmpl.Funcs()
+		names := make([]string, 0, len(funcs))
+		for name := range funcs {
+			names = append(names, name)
+		}
+		names = matchFuncs(names, query)
+		if len(names) == 0 {
+			fmt.Fprintf(app.stderr, "No function matching '%s'.\n", query)
+			return exitUsage
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			d, err := envtmpl.Doc(name, funcs[name])
+			if err != nil {
+				d.Error = err.Error()
+			}
+			docs = append(docs, d)
+		}
+		if format == "" {
+			format = "text"
+		}
+	}
+	fail := func(err error) int {
+		fmt.Fprintf(app.stderr, "Help error: %s\n", err)
+		return exitUsage
+	}
+	var tmpl string
+	switch format {
+	case "json":
+		b, err := json.MarshalIndent(docs, "", "  ")
+		if err != nil {
+			return fail(err)
+		}
+		fmt.Fprintf(app.stdout, "%s\n", b)
+		return exitOk
+	case "text":
+		tmpl = helpTextTemplate
+	case "man":
+		tmpl = helpManTemplate
+	case "markdown":
+		tmpl = `{{ range .funcs }}{{ template "funcHelp" . }}{{ end }}`
+	default:
+		fmt.Fprintf(app.stderr, "Unknown format '%s'.\n", *app.flagFormat)
+		return exitUsage
+	}
+	r := envtmpl.New("help", envtmpl.WithFuncs(envtmpl.FuncMap{
+		"man":  &envtmpl.FuncStruct{Fn: manEscape},
+		"join": &envtmpl.FuncStruct{Fn: strings.Join},
+	}))
+	if err := r.Parse("help", tmpl); err != nil {
+		return fail(err)
+	}
+	if err := r.Parse("funcHelp", funcHelpTemplate); err != nil {
+		return fail(err)
+	}
+	data := app.helpData(docs)
+	var flags []*flag.Flag
+	app.flag.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, f)
+	})
+	data["flags"] = flags
+	var b bytes.Buffer
+	if err := r.Render(&b, "help", data); err != nil {
+		return fail(err)
+	}
+	fmt.Fprintf(app.stdout, "%s\n", bytes.TrimSpace(b.Bytes()))
+	return exitOk
+}
+
+// manEscape escapes text for use in a roff document.
+func manEscape(s string) string {
+	s = strings.Replace(s, `\`, `\e`, -1)
+	s = strings.Replace(s, "-", `\-`, -1)
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		if strings.HasPrefix(l, ".") || strings.HasPrefix(l, "'") {
+			lines[i] = `\&` + l
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// matchFuncs returns the function names matching query. An exact name wins,
+// followed by names starting with query, names containing query and then
+// names that are a close fuzzy match. Matching ignores case.
+func matchFuncs(names []string, query string) []string {
+	q := strings.ToLower(query)
+	tiers := []func(string) bool{
+		func(n string) bool { return n == q },
+		func(n string) bool { return strings.HasPrefix(n, q) },
+		func(n string) bool { return strings.Contains(n, q) },
+		func(n string) bool { return isSubsequence(q, n) || editDistance(q, n) <= 2 },
+	}
+	for _, match := range tiers {
+		var m []string
+		for _, n := range names {
+			if match(strings.ToLower(n)) {
+				m = append(m, n)
+			}
+		}
+		if len(m) > 0 {
+			return m
+		}
+	}
+	return nil
+}
+
+// isSubsequence reports whether the runes of q appear in order in s, so
+// that "b64d" matches "base64Decode".
+func isSubsequence(q, s string) bool {
+	r := []rune(q)
+	for _, c := range s {
+		if len(r) > 0 && c == r[0] {
+			r = r[1:]
+		}
+	}
+	return len(r) == 0
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
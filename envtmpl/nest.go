@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// nestVars builds nested data from the variables whose names contain sep,
+// so that APP__DB__HOST becomes .APP.DB.HOST when sep is "__". Maps whose
+// keys are all numbers become slices, so that APP__HOSTS__0 and
+// APP__HOSTS__1 can be ranged over as .APP.HOSTS. Names with an empty
+// segment, such as __CF_USER_TEXT_ENCODING or FOO__, are left flat. It is an
+// error for a name to be both a value and the parent of another value.
+func nestVars(vars map[string]string, sep string) (map[string]interface{}, error) {
+	names := make([]string, 0, len(vars))
+	for k := range vars {
+		if !strings.Contains(k, sep) {
+			continue
+		}
+		empty := false
+		for _, p := range strings.Split(k, sep) {
+			empty = empty || p == ""
+		}
+		if !empty {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	root := make(map[string]interface{})
+	for _, name := range names {
+		parts := strings.Split(name, sep)
+		if _, ok := vars[parts[0]]; ok {
+			return nil, fmt.Errorf("%s is both a value and the parent of %s", parts[0], name)
+		}
+		m := root
+		for i, p := range parts[:len(parts)-1] {
+			switch v := m[p].(type) {
+			case nil:
+				c := make(map[string]interface{})
+				m[p] = c
+				m = c
+			case map[string]interface{}:
+				m = v
+			default:
+				return nil, fmt.Errorf(
+					"%s is both a value and the parent of %s",
+					strings.Join(parts[:i+1], sep),
+					name,
+				)
+			}
+		}
+		last := parts[len(parts)-1]
+		if _, ok := m[last]; ok {
+			return nil, fmt.Errorf("%s is both a value and the parent of other values", name)
+		}
+		m[last] = vars[name]
+	}
+	for k, v := range root {
+		n, err := nestSlices(k, v, sep)
+		if err != nil {
+			return nil, err
+		}
+		root[k] = n
+	}
+	return root, nil
+}
+
+// nestSlices replaces maps below v whose keys are all numbers with slices.
+// The indexes must run from zero without gaps.
+func nestSlices(name string, v interface{}, sep string) (interface{}, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v, nil
+	}
+	numeric := true
+	for k, c := range m {
+		n, err := nestSlices(name+sep+k, c, sep)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = n
+		if i, err := strconv.Atoi(k); err != nil || i < 0 || strconv.Itoa(i) != k {
+			numeric = false
+		}
+	}
+	if !numeric {
+		return m, nil
+	}
+	s := make([]interface{}, len(m))
+	for i := range s {
+		c, ok := m[strconv.Itoa(i)]
+		if !ok {
+			return nil, fmt.Errorf("%s is missing index %d", name, i)
+		}
+		s[i] = c
+	}
+	return s, nil
+}
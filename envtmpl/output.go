@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// writeFileAtomic writes data to a temporary file in the same directory as
+// path, syncs it and then renames it over path. Any existing file is left
+// untouched if something goes wrong along the way.
+//
+// A zero perm keeps the mode of the existing file, falling back to 0644. A
+// uid or gid of -1 keeps that part of the existing file's ownership, and
+// otherwise leaves it as the temporary file was created.
+func writeFileAtomic(path string, data []byte, perm os.FileMode, uid, gid int) error {
+	if fi, err := os.Stat(path); err == nil {
+		if perm == 0 {
+			perm = fi.Mode().Perm()
+		}
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			if uid == -1 {
+				uid = int(st.Uid)
+			}
+			if gid == -1 {
+				gid = int(st.Gid)
+			}
+		}
+	}
+	if perm == 0 {
+		perm = 0644
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		var fi os.FileInfo
+		if fi, err = f.Stat(); err != nil {
+			return err
+		}
+		if st, ok := fi.Sys().(*syscall.Stat_t); !ok || uid != int(st.Uid) || gid != int(st.Gid) {
+			if err = f.Chown(uid, gid); err != nil {
+				return err
+			}
+		}
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	err = os.Rename(tmp, path)
+	return err
+}
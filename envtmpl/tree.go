@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// renderTree parses every template below the job's tmplDir and renders each
+// one, apart from partials whose names start with an underscore, to the
+// mirrored path below destDir with its extension removed. Other files are
+// copied as they are. File modes are kept unless -mode is given. Templates
+// in -I directories are parsed as partials.
+func (app *cli) renderTree(j *job) int {
+	r := app.renderer(j.tmplDir)
+	var dirs, files []string
+	modes := make(map[string]os.FileMode)
+	var targets []target
+	destDir, _ := filepath.Abs(j.destDir)
+	// Partials from -I directories are parsed first, so that templates
+	// below srcDir can override their blocks.
+	var err error
+	if len(app.flagIncludes) > 0 {
+		var partials []string
+		for _, dir := range app.flagIncludes {
+			for _, p := range app.patterns() {
+				matches, _ := filepath.Glob(filepath.Join(dir, p))
+				partials = append(partials, matches...)
+			}
+		}
+		err = app.parseFiles(r, partials)
+	}
+	if err == nil {
+		err = filepath.Walk(j.tmplDir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if abs, _ := filepath.Abs(path); abs == destDir && fi.IsDir() {
+				return filepath.SkipDir
+			}
+			rel, err := filepath.Rel(j.tmplDir, path)
+			if err != nil {
+				return err
+			}
+			modes[rel] = fi.Mode().Perm()
+			switch {
+			case fi.IsDir():
+				dirs = append(dirs, rel)
+			case !fi.Mode().IsRegular():
+			case app.isTemplate(fi.Name()):
+				b, err := ioutil.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				name := filepath.ToSlash(rel)
+				src, err := app.source(name, path, b)
+				if err == nil {
+					err = r.ParseSources(src)
+				}
+				if err != nil {
+					return err
+				}
+				if !strings.HasPrefix(fi.Name(), "_") {
+					t := target{
+						name:   name,
+						output: filepath.Join(j.destDir, strings.TrimSuffix(rel, filepath.Ext(rel))),
+						perm:   fi.Mode().Perm(),
+					}
+					if fm := app.frontMatter[name]; fm != nil && fm.Output != "" {
+						t.output = fm.Output
+						if !filepath.IsAbs(t.output) {
+							t.output = filepath.Join(j.destDir, t.output)
+						}
+					}
+					targets = append(targets, t)
+				}
+			default:
+				files = append(files, rel)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return app.parseFailure(j.tmplDir, err)
+	}
+
+	data, err := app.data()
+	if err != nil {
+		fmt.Fprintf(app.stderr, "Data error: %s\n", err)
+		return exitDataError
+	}
+
+	for _, d := range dirs {
+		if err := os.MkdirAll(filepath.Join(j.destDir, d), modes[d]); err != nil {
+			fmt.Fprintf(app.stderr, "Output error: %s\n", err)
+			return exitOutputError
+		}
+	}
+	var failedCopy int
+	for _, f := range files {
+		perm := j.perm
+		if perm == 0 {
+			perm = modes[f]
+		}
+		b, err := ioutil.ReadFile(filepath.Join(j.tmplDir, f))
+		if err == nil {
+			err = writeFileAtomic(filepath.Join(j.destDir, f), b, perm, *app.flagUid, *app.flagGid)
+		}
+		if err != nil {
+			fmt.Fprintf(app.stderr, "Output error: %s\n", err)
+			failedCopy++
+		}
+	}
+
+	tj := *j
+	tj.targets = targets
+	code := app.render(r, &tj, data)
+	if code == exitOk && failedCopy > 0 {
+		return exitOutputError
+	}
+	return code
+}
+
+// isTemplate reports whether a file name matches a -pattern.
+func (app *cli) isTemplate(name string) bool {
+	for _, p := range app.patterns() {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
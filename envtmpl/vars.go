@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/williambailey/go-envtmpl"
+)
+
+// varRef is a reference to a top level field of the template data, or a
+// lookup that couldn't be resolved statically.
+type varRef struct {
+	Name    string `json:"name,omitempty"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Dynamic string `json:"dynamic,omitempty"`
+}
+
+// varScanner walks parse trees looking for references to the root data.
+type varScanner struct {
+	tmpl    *template.Template
+	path    func(name string) string
+	visited map[string]bool
+	refs    []varRef
+}
+
+// vars reports the environment variables used by each target.
+func (app *cli) vars(r *envtmpl.Renderer, j *job) int {
+	tmpl := r.Template()
+	s := &varScanner{
+		tmpl:    tmpl,
+		path:    func(name string) string { return app.templateFile(j.tmplDir, name) },
+		visited: make(map[string]bool),
+	}
+	for _, t := range j.targets {
+		if tmpl.Lookup(t.name) == nil {
+			fmt.Fprintf(app.stderr, "Template execution: template: no template %q associated with template %q\n", t.name, tmpl.Name())
+			return exitTemplateExecutionError
+		}
+		s.template(t.name, true, true)
+	}
+	sort.SliceStable(s.refs, func(i, j int) bool {
+		a, b := s.refs[i], s.refs[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	if *app.flagFormat == "json" {
+		refs := s.refs
+		if refs == nil {
+			refs = []varRef{}
+		}
+		b, _ := json.MarshalIndent(refs, "", "  ")
+		fmt.Fprintf(app.stdout, "%s\n", b)
+		return exitOk
+	}
+	for _, r := range s.refs {
+		if r.Dynamic != "" {
+			fmt.Fprintf(app.stdout, "%s:%d:%d: dynamic lookup: %s\n", r.File, r.Line, r.Column, r.Dynamic)
+			continue
+		}
+		fmt.Fprintf(app.stdout, "%s:%d:%d: %s\n", r.File, r.Line, r.Column, r.Name)
+	}
+	return exitOk
+}
+
+// template scans the named template. root reports whether $ refers to the
+// root data and dot whether . does.
+func (s *varScanner) template(name string, root, dot bool) {
+	k := fmt.Sprintf("%s\x00%t\x00%t", name, root, dot)
+	if s.visited[k] {
+		return
+	}
+	s.visited[k] = true
+	t := s.tmpl.Lookup(name)
+	if t == nil || t.Tree == nil {
+		return
+	}
+	s.node(t.Tree, t.Tree.Root, root, dot)
+}
+
+func (s *varScanner) add(tree *parse.Tree, n parse.Node, name, dynamic string) {
+	r := varRef{Name: name, Dynamic: dynamic}
+	r.File, r.Line, r.Column = nodeLocation(s.path, tree, n)
+	s.refs = append(s.refs, r)
+}
+
+// nodeLocation returns the file, line and 1-based column of n. The path of
+// the file is given by path from the name of the template file.
+func nodeLocation(path func(string) string, tree *parse.Tree, n parse.Node) (file string, line, col int) {
+	loc, _ := tree.ErrorContext(n)
+	// loc is name:line:col, where name may itself contain colons.
+	p := strings.Split(loc, ":")
+	if len(p) >= 3 {
+		file = strings.Join(p[:len(p)-2], ":")
+		line, _ = strconv.Atoi(p[len(p)-2])
+		col, _ = strconv.Atoi(p[len(p)-1])
+	}
+	return path(file), line, col + 1
+}
+
+func (s *varScanner) node(tree *parse.Tree, n parse.Node, root, dot bool) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			s.node(tree, c, root, dot)
+		}
+	case *parse.ActionNode:
+		s.node(tree, n.Pipe, root, dot)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			s.command(tree, c, root, dot)
+		}
+	case *parse.IfNode:
+		s.node(tree, n.Pipe, root, dot)
+		s.node(tree, n.List, root, dot)
+		s.node(tree, n.ElseList, root, dot)
+	case *parse.WithNode:
+		s.node(tree, n.Pipe, root, dot)
+		s.node(tree, n.List, root, false)
+		s.node(tree, n.ElseList, root, dot)
+	case *parse.RangeNode:
+		s.node(tree, n.Pipe, root, dot)
+		s.node(tree, n.List, root, false)
+		s.node(tree, n.ElseList, root, dot)
+	case *parse.TemplateNode:
+		s.node(tree, n.Pipe, root, dot)
+		s.template(n.Name, s.passesRoot(n.Pipe, root, dot), s.passesRoot(n.Pipe, root, dot))
+	case *parse.FieldNode:
+		if dot {
+			s.add(tree, n, n.Ident[0], "")
+		}
+	case *parse.VariableNode:
+		if root && n.Ident[0] == "$" && len(n.Ident) > 1 {
+			s.add(tree, n, n.Ident[1], "")
+		}
+	case *parse.ChainNode:
+		if _, ok := n.Node.(*parse.DotNode); ok && dot && len(n.Field) > 0 {
+			s.add(tree, n, n.Field[0], "")
+			return
+		}
+		s.node(tree, n.Node, root, dot)
+	}
+}
+
+func (s *varScanner) command(tree *parse.Tree, c *parse.CommandNode, root, dot bool) {
+	if len(c.Args) > 0 {
+		if id, ok := c.Args[0].(*parse.IdentifierNode); ok {
+			switch id.Ident {
+			case "include":
+				if len(c.Args) != 3 {
+					break
+				}
+				name, ok := c.Args[1].(*parse.StringNode)
+				if !ok {
+					s.add(tree, c, "", c.String())
+					break
+				}
+				p := &parse.PipeNode{Cmds: []*parse.CommandNode{{Args: c.Args[2:]}}}
+				s.template(name.Text, s.passesRoot(p, root, dot), s.passesRoot(p, root, dot))
+			case "index":
+				if len(c.Args) < 3 || !s.isRoot(c.Args[1], root, dot) {
+					break
+				}
+				if k, ok := c.Args[2].(*parse.StringNode); ok {
+					s.add(tree, k, k.Text, "")
+				} else {
+					s.add(tree, c, "", c.String())
+				}
+			}
+		}
+	}
+	for _, a := range c.Args {
+		s.node(tree, a, root, dot)
+	}
+}
+
+// isRoot reports whether n evaluates to the root data.
+func (s *varScanner) isRoot(n parse.Node, root, dot bool) bool {
+	switch n := n.(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.VariableNode:
+		return root && len(n.Ident) == 1 && n.Ident[0] == "$"
+	}
+	return false
+}
+
+// passesRoot reports whether a pipeline, as passed to template or include,
+// evaluates to the root data.
+func (s *varScanner) passesRoot(p *parse.PipeNode, root, dot bool) bool {
+	if p == nil || len(p.Decl) > 0 || len(p.Cmds) != 1 || len(p.Cmds[0].Args) != 1 {
+		return false
+	}
+	return s.isRoot(p.Cmds[0].Args[0], root, dot)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// watch renders the job and then polls the files it depends on, rendering
+// again each time they change. It only returns once stop is closed, which
+// never happens when stop is nil.
+func (app *cli) watch(j *job, stop <-chan struct{}) int {
+	last := app.watchState(j.tmplDir)
+	app.execute(j)
+	t := time.NewTicker(*app.flagWatchEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return exitOk
+		case <-t.C:
+		}
+		s := app.watchState(j.tmplDir)
+		if s == last {
+			continue
+		}
+		last = s
+		app.execute(j)
+	}
+}
+
+// watchState summarises the modification time and size of every file the
+// job depends on. Files that come and go are also picked up.
+func (app *cli) watchState(tmplDir string) string {
+	files, _ := app.templateFiles(tmplDir)
+	files = append(files, app.flagEnvFiles...)
+	for _, s := range app.flagData {
+		if _, _, file, err := parseDataFlag(s); err == nil {
+			files = append(files, file)
+		}
+	}
+	for _, o := range app.overrides {
+		if o.flag == "var-file" {
+			if i := strings.Index(o.arg, "="); i > 0 {
+				files = append(files, o.arg[i+1:])
+			}
+		}
+	}
+	sort.Strings(files)
+	var state string
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			state += fmt.Sprintf("%s\x00-\n", f)
+			continue
+		}
+		state += fmt.Sprintf("%s\x00%d\x00%d\n", f, fi.ModTime().UnixNano(), fi.Size())
+	}
+	return state
+}
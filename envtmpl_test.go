@@ -0,0 +1,227 @@
+package envtmpl
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+)
+
+func TestRendererRendersWithRegisteredFuncs(t *testing.T) {
+	r := New("test", WithDelims("[[", "]]"), WithData(map[string]string{"WHAT": "World"}))
+	if err := r.Parse("foo", `Hello [[ .WHAT | upper ]]!`); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := r.Render(&b, "foo", nil); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "Hello WORLD!" {
+		t.Errorf("Expecting `Hello WORLD!` got `%s`", b.String())
+	}
+	funcs, release := r.FuncMap()
+	if _, ok := funcs["upper"]; !ok {
+		t.Error("Expecting FuncMap to contain upper")
+	}
+	funcs["set"].(func(interface{}, interface{}) string)("k", "v")
+	if v := funcs["get"].(func(interface{}) interface{})("k"); v != "v" {
+		t.Errorf("Expecting get to return `v` got `%v`", v)
+	}
+	other, releaseOther := r.FuncMap()
+	defer releaseOther()
+	if v := other["get"].(func(interface{}) interface{})("k"); v != nil {
+		t.Errorf("Expecting each FuncMap to have its own store, got `%v`", v)
+	}
+	release()
+	if v := funcs["get"].(func(interface{}) interface{})("k"); v != nil {
+		t.Errorf("Expecting release to clear the store, got `%v`", v)
+	}
+	release()
+}
+
+func TestRendererWithStrictFailsOnMissingKey(t *testing.T) {
+	r := New("test", WithStrict())
+	if err := r.Parse("foo", `Hello {{ .WHAT }}!`); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	err := r.Render(&b, "foo", map[string]string{})
+	if err == nil || !strings.Contains(err.Error(), `map has no entry for key "WHAT"`) {
+		t.Errorf("Expecting a missing key error, got `%v`", err)
+	}
+}
+
+func TestRendererWithFuncs(t *testing.T) {
+	r := New("test", WithFuncs(FuncMap{
+		"upper": &FuncStruct{Fn: strings.ToLower},
+		"name": &FuncFactoryStruct{Fn: func(t *template.Template, _ *Runtime) interface{} {
+			return func() string { return t.Name() }
+		}},
+	}))
+	if err := r.Parse("foo", `{{ "ABC" | upper }} {{ name }}`); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := r.Render(&b, "foo", nil); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "abc test" {
+		t.Errorf("Expecting `abc test` got `%s`", b.String())
+	}
+	if _, ok := Funcs()["name"]; ok {
+		t.Error("Expecting WithFuncs not to register name globally")
+	}
+}
+
+func TestRegisterPanicsOnDuplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expecting Register to panic on a duplicate name")
+		}
+	}()
+	Register("upper", &FuncStruct{Fn: strings.ToUpper})
+}
+
+func TestExamples(t *testing.T) {
+	ex, err := Examples("upper", Funcs()["upper"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex[`{{ "Hello World!" | upper }}`] != "HELLO WORLD!" {
+		t.Errorf("Unexpected examples %v", ex)
+	}
+}
+
+func TestDocsRendersEveryExample(t *testing.T) {
+	for _, d := range Docs() {
+		if d.Error != "" {
+			t.Errorf("Expecting the examples of %s to render, got `%s`", d.Name, d.Error)
+		}
+	}
+	_, err := Doc("upper", &FuncStruct{
+		Examples: []string{`{{ %s "a" "b" }}`},
+		Fn:       strings.ToUpper,
+	})
+	if err == nil {
+		t.Error("Expecting Doc to return the example error")
+	}
+}
+
+func TestRendererRenderScopesDataStore(t *testing.T) {
+	r := New("test")
+	err := r.Parse("foo", `{{ define "t" }}{{ incr "n" }}{{ end }}{{ hasKey "n" }} {{ template "t" }} {{ include "t" . }} {{ set "k" . }}{{ get "k" }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var b bytes.Buffer
+			if err := r.Render(&b, "foo", i); err != nil {
+				t.Error(err)
+				return
+			}
+			ex := fmt.Sprintf("false 1 2 %d", i)
+			if b.String() != ex {
+				t.Errorf("Expecting `%s` got `%s`", ex, b.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRendererWithRuntime(t *testing.T) {
+	rt := ExampleRuntime()
+	rt.FS = MapFS{"secret.txt": "s3cret"}
+	r := New("test", WithRuntime(rt))
+	if err := r.Parse("foo", `{{ file "secret.txt" }} {{ uuid }}`); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := r.Render(&b, "foo", nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := "s3cret 00010203-0405-4607-8809-0a0b0c0d0e0f"
+	if b.String() != expected {
+		t.Errorf("Expecting `%s` got `%s`", expected, b.String())
+	}
+	if err := r.Parse("bar", `{{ file "missing.txt" }}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Render(&b, "bar", nil); err == nil {
+		t.Error("Expecting an error reading a missing file")
+	}
+}
+
+func TestRendererParseFilesReportsCollisions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envtmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, b := filepath.Join(dir, "a.tmpl"), filepath.Join(dir, "b.tmpl")
+	ioutil.WriteFile(a, []byte(`{{ define "x" }}a{{ end }}`), 0644)
+	r := New("test")
+	if err := r.ParseFiles(a); err != nil {
+		t.Fatal(err)
+	}
+	err = r.ParseFiles(a)
+	ex := "template: " + a + `: "a.tmpl" is already defined by ` + a
+	if err == nil || err.Error() != ex {
+		t.Errorf("Expecting error `%s` got `%v`", ex, err)
+	}
+
+	err = New("test").ParseSources(
+		Source{Name: "a.tmpl", File: a, Text: `{{ define "x" }}a{{ end }}`, Group: "one"},
+		Source{Name: "b.tmpl", File: b, Text: `{{ define "x" }}b{{ end }}`, Group: "two"},
+	)
+	ex = "template: " + b + `: "x" is already defined by ` + a
+	if err == nil || err.Error() != ex {
+		t.Errorf("Expecting error `%s` got `%v`", ex, err)
+	}
+}
+
+func TestRendererParseFilesOverridesBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envtmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	base, page := filepath.Join(dir, "a_base.tmpl"), filepath.Join(dir, "page.tmpl")
+	ioutil.WriteFile(base, []byte(`{{ define "base" }}<{{ block "content" . }}default{{ end }}>{{ end }}`), 0644)
+	ioutil.WriteFile(page, []byte(`{{ define "content" }}page{{ end }}{{ template "base" . }}`), 0644)
+	r := New("test")
+	if err := r.ParseFiles(base, page); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := r.Render(&b, "page.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "<page>" {
+		t.Errorf("Expecting `<page>` got `%s`", b.String())
+	}
+
+	r = New("test")
+	err = r.ParseSources(
+		Source{Name: "a.tmpl", Text: `{{ define "x" }}a{{ end }}`, Group: "one"},
+		Source{Name: "b.tmpl", Text: `{{ define "x" }}b{{ end }}`, Group: "one"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	if err := r.Render(&b, "x", nil); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "b" {
+		t.Errorf("Expecting `b` got `%s`", b.String())
+	}
+}
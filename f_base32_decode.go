@@ -1,14 +1,14 @@
-package main
+package envtmpl
 
 import "encoding/base32"
 
 func init() {
-	funcMap["base32Decode"] = &tmplFuncStruct{
-		short: "Decodes a base32 string.",
-		examples: []string{
+	funcMap["base32Decode"] = &FuncStruct{
+		Short: "Decodes a base32 string.",
+		Examples: []string{
 			`{{ "JBSWY3DPEBLU6USMIQQQ====" | %s }}`,
 		},
-		fn: func(str string) (string, error) {
+		Fn: func(str string) (string, error) {
 			data, err := base32.StdEncoding.DecodeString(str)
 			if err != nil {
 				return "", err
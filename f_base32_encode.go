@@ -1,14 +1,14 @@
-package main
+package envtmpl
 
 import "encoding/base32"
 
 func init() {
-	funcMap["base32Encode"] = &tmplFuncStruct{
-		short: "Encodes a value to base32.",
-		examples: []string{
+	funcMap["base32Encode"] = &FuncStruct{
+		Short: "Encodes a value to base32.",
+		Examples: []string{
 			`{{ "Hello WORLD!" | %s }}`,
 		},
-		fn: func(src string) (string, error) {
+		Fn: func(src string) (string, error) {
 			data := []byte(src)
 			return base32.StdEncoding.EncodeToString(data), nil
 		},
@@ -1,14 +1,14 @@
-package main
+package envtmpl
 
 import "encoding/base64"
 
 func init() {
-	funcMap["base64Decode"] = &tmplFuncStruct{
-		short: "Decodes a base64 string.",
-		examples: []string{
+	funcMap["base64Decode"] = &FuncStruct{
+		Short: "Decodes a base64 string.",
+		Examples: []string{
 			`{{ "SGVsbG8gV09STEQh" | %s }}`,
 		},
-		fn: func(str string) (string, error) {
+		Fn: func(str string) (string, error) {
 			data, err := base64.StdEncoding.DecodeString(str)
 			if err != nil {
 				return "", err
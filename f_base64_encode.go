@@ -1,14 +1,14 @@
-package main
+package envtmpl
 
 import "encoding/base64"
 
 func init() {
-	funcMap["base64Encode"] = &tmplFuncStruct{
-		short: "Encodes a value to base64.",
-		examples: []string{
+	funcMap["base64Encode"] = &FuncStruct{
+		Short: "Encodes a value to base64.",
+		Examples: []string{
 			`{{ "Hello WORLD!" | %s }}`,
 		},
-		fn: func(src string) (string, error) {
+		Fn: func(src string) (string, error) {
 			data := []byte(src)
 			return base64.StdEncoding.EncodeToString(data), nil
 		},
@@ -0,0 +1,16 @@
+package envtmpl
+
+import "text/template"
+
+func init() {
+	funcMap["file"] = &FuncFactoryStruct{
+		Short:    "Read the contents of a file.",
+		Examples: []string{`{{ %s "../example/hello.txt" }}`},
+		Fn: func(_ *template.Template, rt *Runtime) interface{} {
+			return func(file string) (string, error) {
+				b, err := rt.FS.ReadFile(file)
+				return string(b), err
+			}
+		},
+	}
+}
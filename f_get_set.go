@@ -0,0 +1,154 @@
+package envtmpl
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"text/template"
+)
+
+// dataStore holds the values set by templates. Each render has its own store,
+// keyed by the template it is bound to, which is released when rendering
+// finishes.
+type dataStore struct {
+	mu   sync.Mutex
+	data map[*template.Template]map[interface{}]interface{}
+}
+
+// do calls fn with the store for t while holding the lock.
+func (s *dataStore) do(t *template.Template, fn func(map[interface{}]interface{})) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.data[t]
+	if !ok {
+		d = make(map[interface{}]interface{})
+		s.data[t] = d
+	}
+	fn(d)
+}
+
+func (s *dataStore) release(t *template.Template) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, t)
+}
+
+func init() {
+	store := &dataStore{data: make(map[*template.Template]map[interface{}]interface{})}
+	funcMap["get"] = &FuncFactoryStruct{
+		Short: "Get a value from the data store. The data store is shared by every template executed within a single render.",
+		Examples: []string{
+			`{{ define "t1" }}[{{ %s "key" }}]{{ end }}
+{{ set "key" "value" }}{{ template "t1" }}`,
+		},
+		Fn: func(t *template.Template, _ *Runtime) interface{} {
+			return func(k interface{}) (v interface{}) {
+				store.do(t, func(d map[interface{}]interface{}) {
+					v = d[k]
+				})
+				return v
+			}
+		},
+		Cleanup: store.release,
+	}
+	funcMap["set"] = &FuncFactoryStruct{
+		Short: "Set a value in the data store.",
+		Examples: []string{
+			`{{ define "t1" }}[{{ get "key" }}]{{ end }}
+{{ %s "key" "value" }}{{ template "t1" }}`,
+		},
+		Fn: func(t *template.Template, _ *Runtime) interface{} {
+			return func(k interface{}, v interface{}) string {
+				store.do(t, func(d map[interface{}]interface{}) {
+					d[k] = v
+				})
+				return ""
+			}
+		},
+		Cleanup: store.release,
+	}
+	funcMap["unset"] = &FuncFactoryStruct{
+		Short: "Remove a value from the data store.",
+		Examples: []string{
+			`{{ set "key" "value" }}{{ %s "key" }}[{{ get "key" }}]`,
+		},
+		Fn: func(t *template.Template, _ *Runtime) interface{} {
+			return func(k interface{}) string {
+				store.do(t, func(d map[interface{}]interface{}) {
+					delete(d, k)
+				})
+				return ""
+			}
+		},
+		Cleanup: store.release,
+	}
+	funcMap["hasKey"] = &FuncFactoryStruct{
+		Short: "Check whether a value is in the data store.",
+		Examples: []string{
+			`{{ %[1]s "key" }} {{ set "key" "value" }}{{ %[1]s "key" }}`,
+		},
+		Fn: func(t *template.Template, _ *Runtime) interface{} {
+			return func(k interface{}) (ok bool) {
+				store.do(t, func(d map[interface{}]interface{}) {
+					_, ok = d[k]
+				})
+				return ok
+			}
+		},
+		Cleanup: store.release,
+	}
+	funcMap["append"] = &FuncFactoryStruct{
+		Short: "Append a value to a list in the data store, creating the list if needed.",
+		Examples: []string{
+			`{{ %[1]s "key" "a" }}{{ %[1]s "key" "b" }}{{ range get "key" }}[{{ . }}]{{ end }}`,
+		},
+		Fn: func(t *template.Template, _ *Runtime) interface{} {
+			return func(k interface{}, v interface{}) (s string, err error) {
+				store.do(t, func(d map[interface{}]interface{}) {
+					switch l := d[k].(type) {
+					case nil:
+						d[k] = []interface{}{v}
+					case []interface{}:
+						d[k] = append(l, v)
+					default:
+						err = fmt.Errorf("Value for '%v' is not a list.", k)
+					}
+				})
+				return "", err
+			}
+		},
+		Cleanup: store.release,
+	}
+	funcMap["incr"] = &FuncFactoryStruct{
+		Short: "Increment a counter in the data store, returning the new value. You can optionally specify the amount to increment by. Counters start at 0.",
+		Examples: []string{
+			`{{ %[1]s "key" }} {{ %[1]s "key" }} {{ %[1]s "key" 10 }}`,
+		},
+		Fn: func(t *template.Template, _ *Runtime) interface{} {
+			return func(k interface{}, by ...int) (n int, err error) {
+				inc := 1
+				switch len(by) {
+				case 0:
+				case 1:
+					inc = by[0]
+				default:
+					return 0, errors.New("Expecting 1 or 2 arguments.")
+				}
+				store.do(t, func(d map[interface{}]interface{}) {
+					switch v := d[k].(type) {
+					case nil:
+					case int:
+						n = v
+					default:
+						err = fmt.Errorf("Value for '%v' is not a counter.", k)
+						return
+					}
+					n += inc
+					d[k] = n
+				})
+				return n, err
+			}
+		},
+		Cleanup: store.release,
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package envtmpl
 
 import (
 	"crypto/hmac"
@@ -69,13 +69,13 @@ func init() {
 		)
 	}
 	sort.Strings(idList)
-	funcMap["hash"] = &tmplFuncStruct{
-		short: fmt.Sprintf(
+	funcMap["hash"] = &FuncStruct{
+		Short: fmt.Sprintf(
 			"Calculate the hex encoded hash of a string. You can optionally specify a key to produce a HMAC string. The following hash algorithms are supported: %s.",
 			strings.Join(idList, ", "),
 		),
-		examples: exList,
-		fn: func(in ...string) (string, error) {
+		Examples: exList,
+		Fn: func(in ...string) (string, error) {
 			var data, hashName, macKey string
 			var h hash.Hash
 			switch len(in) {
@@ -1,14 +1,14 @@
-package main
+package envtmpl
 
 import "encoding/hex"
 
 func init() {
-	funcMap["hexDecode"] = &tmplFuncStruct{
-		short: "Decodes a hex string.",
-		examples: []string{
+	funcMap["hexDecode"] = &FuncStruct{
+		Short: "Decodes a hex string.",
+		Examples: []string{
 			`{{ "48656c6c6f20574f524c4421" | %s }}`,
 		},
-		fn: func(str string) (string, error) {
+		Fn: func(str string) (string, error) {
 			data, err := hex.DecodeString(str)
 			if err != nil {
 				return "", err
@@ -0,0 +1,16 @@
+package envtmpl
+
+import "encoding/hex"
+
+func init() {
+	funcMap["hexEncode"] = &FuncStruct{
+		Short: "Encodes a value to hex.",
+		Examples: []string{
+			`{{ "Hello WORLD!" | %s }}`,
+		},
+		Fn: func(src string) (string, error) {
+			data := []byte(src)
+			return hex.EncodeToString(data), nil
+		},
+	}
+}
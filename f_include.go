@@ -1,4 +1,4 @@
-package main
+package envtmpl
 
 import (
 	"bytes"
@@ -6,10 +6,10 @@ import (
 )
 
 func init() {
-	funcMap["include"] = &tmplFuncFactoryStruct{
-		short:    "Include a template. Differs from the template keyword in that it can accept the template name as part of a pipeline.",
-		examples: []string{`{{define "ex"}}FOO is {{ .FOO }}{{end}}{{ $t := "ex" }}>>{{ %s $t . }}<<`},
-		fn: func(t *template.Template) interface{} {
+	funcMap["include"] = &FuncFactoryStruct{
+		Short:    "Include a template. Differs from the template keyword in that it can accept the template name as part of a pipeline.",
+		Examples: []string{`{{define "ex"}}FOO is {{ .FOO }}{{end}}{{ $t := "ex" }}>>{{ %s $t . }}<<`},
+		Fn: func(t *template.Template, _ *Runtime) interface{} {
 			return func(template string, data interface{}) (string, error) {
 				var b bytes.Buffer
 				err := t.ExecuteTemplate(&b, template, data)
@@ -1,14 +1,14 @@
-package main
+package envtmpl
 
 import "encoding/json"
 
 func init() {
-	funcMap["jsonDecode"] = &tmplFuncStruct{
-		short: "Decodes a JSON string.",
-		examples: []string{
+	funcMap["jsonDecode"] = &FuncStruct{
+		Short: "Decodes a JSON string.",
+		Examples: []string{
 			`{{ $j := "{\"foo\":\"bar\"}" | %s }}Foo is {{ $j.foo }}`,
 		},
-		fn: func(src string) (interface{}, error) {
+		Fn: func(src string) (interface{}, error) {
 			var o interface{}
 			err := json.Unmarshal([]byte(src), &o)
 			if err != nil {
@@ -1,4 +1,4 @@
-package main
+package envtmpl
 
 import (
 	"bytes"
@@ -6,13 +6,13 @@ import (
 )
 
 func init() {
-	funcMap["jsonEncode"] = &tmplFuncStruct{
-		short: "Encodes a value to JSON.",
-		examples: []string{
+	funcMap["jsonEncode"] = &FuncStruct{
+		Short: "Encodes a value to JSON.",
+		Examples: []string{
 			`{{ "Hello\n<WORLD>!" | %s }}`,
 			`{{ split "Hello\n<WORLD>!" "\n" | %s }}`,
 		},
-		fn: func(src interface{}) (string, error) {
+		Fn: func(src interface{}) (string, error) {
 			b, err := json.Marshal(src)
 			if err != nil {
 				return "", err
@@ -1,4 +1,4 @@
-package main
+package envtmpl
 
 import (
 	"bytes"
@@ -6,12 +6,12 @@ import (
 )
 
 func init() {
-	funcMap["linePrefix"] = &tmplFuncStruct{
-		short: "Prefix each line.",
-		examples: []string{
+	funcMap["linePrefix"] = &FuncStruct{
+		Short: "Prefix each line.",
+		Examples: []string{
 			`{{ "line1\nline2\nline3" | %s "- " }}`,
 		},
-		fn: func(prefix, data string) string {
+		Fn: func(prefix, data string) string {
 			var b bytes.Buffer
 			for _, v := range strings.SplitAfter(data, "\n") {
 				b.WriteString(prefix)
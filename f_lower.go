@@ -0,0 +1,13 @@
+package envtmpl
+
+import "strings"
+
+func init() {
+	funcMap["lower"] = &FuncStruct{
+		Short: "Convert to lower case.",
+		Examples: []string{
+			`{{ "Hello WORLD!" | %s }}`,
+		},
+		Fn: strings.ToLower,
+	}
+}
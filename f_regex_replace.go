@@ -1,14 +1,14 @@
-package main
+package envtmpl
 
 import "regexp"
 
 func init() {
-	funcMap["regexReplace"] = &tmplFuncStruct{
-		short: "Replace values using a regular expression.",
-		examples: []string{
+	funcMap["regexReplace"] = &FuncStruct{
+		Short: "Replace values using a regular expression.",
+		Examples: []string{
 			`{{ "this is something" | %s "(this) is " "[$1] was " }}`,
 		},
-		fn: func(search, replace, src string) (string, error) {
+		Fn: func(search, replace, src string) (string, error) {
 			re, err := regexp.Compile(search)
 			if err != nil {
 				return "", err
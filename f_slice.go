@@ -0,0 +1,14 @@
+package envtmpl
+
+import "code.google.com/p/go.exp/utf8string"
+
+func init() {
+	funcMap["slice"] = &FuncStruct{
+		Short:    "Construct a substring from a string.",
+		Examples: []string{`{{ "ᛁᚳ᛫ᛗᚨᚷ᛫ᚷᛚᚨᛋ᛫" | %s 3 7 }}`},
+		Fn: func(low int, high int, in string) string {
+			s := utf8string.NewString(in)
+			return s.Slice(low, high)
+		},
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package envtmpl
 
 import (
 	"errors"
@@ -13,9 +13,9 @@ import (
 func init() {
 	allowed := []*unicode.RangeTable{unicode.Letter, unicode.Number}
 	dashes := regexp.MustCompile("-+")
-	funcMap["slugify"] = &tmplFuncStruct{
-		short: "Transform text to a slugified version. You can optionally specify a unicode normalization rule (NFC, NFD, NFKC, NFKD). Default is NFC.",
-		examples: []string{
+	funcMap["slugify"] = &FuncStruct{
+		Short: "Transform text to a slugified version. You can optionally specify a unicode normalization rule (NFC, NFD, NFKC, NFKD). Default is NFC.",
+		Examples: []string{
 			`{{ "Hello WORLD!" | %s }}`,
 			`{{ "Hello W/O-R_L~D!" | %s }}`,
 			` NFC: {{ "Hello áçćèńtš!" | %[1]s "NFC" }}
@@ -23,7 +23,7 @@ func init() {
 NFKC: {{ "Hello áçćèńtš!" | %[1]s "NFKC" }}
 NFKD: {{ "Hello áçćèńtš!" | %[1]s "NFKD" }}`,
 		},
-		fn: func(in ...string) (string, error) {
+		Fn: func(in ...string) (string, error) {
 			var src, mode string
 			switch len(in) {
 			case 1:
@@ -0,0 +1,13 @@
+package envtmpl
+
+import "strings"
+
+func init() {
+	funcMap["split"] = &FuncStruct{
+		Short: "Split in a string substrings using another string.",
+		Examples: []string{
+			`{{ range $k, $v := %s "foo BAR bAz" " " }}{{ $k }}={{ $v }} {{ end }}`,
+		},
+		Fn: strings.Split,
+	}
+}
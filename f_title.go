@@ -0,0 +1,13 @@
+package envtmpl
+
+import "strings"
+
+func init() {
+	funcMap["title"] = &FuncStruct{
+		Short: "Convert to title case.",
+		Examples: []string{
+			`{{ "foo BAR bAz" | %s }}`,
+		},
+		Fn: strings.Title,
+	}
+}
@@ -1,15 +1,15 @@
-package main
+package envtmpl
 
 import "strings"
 
 func init() {
-	funcMap["trimPrefix"] = &tmplFuncStruct{
-		short: "Remove leading prefix. If the string doesn't start with the prefix then it's unchanged.",
-		examples: []string{
+	funcMap["trimPrefix"] = &FuncStruct{
+		Short: "Remove leading prefix. If the string doesn't start with the prefix then it's unchanged.",
+		Examples: []string{
 			`{{ "foo.bar" | %s "foo." }}`,
 			`{{ "foo.bar" | %s "baz." }}`,
 		},
-		fn: func(prefix, s string) string {
+		Fn: func(prefix, s string) string {
 			return strings.TrimPrefix(s, prefix)
 		},
 	}
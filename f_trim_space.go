@@ -0,0 +1,13 @@
+package envtmpl
+
+import "strings"
+
+func init() {
+	funcMap["trimSpace"] = &FuncStruct{
+		Short: "Remove all leading and trailing white space.",
+		Examples: []string{
+			`{{ " \t\n foo bar \t\n " | %s }}`,
+		},
+		Fn: strings.TrimSpace,
+	}
+}
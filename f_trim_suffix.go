@@ -1,15 +1,15 @@
-package main
+package envtmpl
 
 import "strings"
 
 func init() {
-	funcMap["trimSuffix"] = &tmplFuncStruct{
-		short: "Remove trailing suffix. If the string doesn't end with the suffix then it's unchanged.",
-		examples: []string{
+	funcMap["trimSuffix"] = &FuncStruct{
+		Short: "Remove trailing suffix. If the string doesn't end with the suffix then it's unchanged.",
+		Examples: []string{
 			`{{ "foo.bar" | %s ".bar" }}`,
 			`{{ "foo.bar" | %s ".baz" }}`,
 		},
-		fn: func(prefix, s string) string {
+		Fn: func(prefix, s string) string {
 			return strings.TrimSuffix(s, prefix)
 		},
 	}
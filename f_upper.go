@@ -0,0 +1,13 @@
+package envtmpl
+
+import "strings"
+
+func init() {
+	funcMap["upper"] = &FuncStruct{
+		Short: "Convert to upper case.",
+		Examples: []string{
+			`{{ "Hello World!" | %s }}`,
+		},
+		Fn: strings.ToUpper,
+	}
+}
@@ -0,0 +1,13 @@
+package envtmpl
+
+import "net/url"
+
+func init() {
+	funcMap["urlEscape"] = &FuncStruct{
+		Short: "Escapes the string so it can be safely placed inside a URL query.",
+		Examples: []string{
+			`{{ "Hello World!" | %s }}`,
+		},
+		Fn: url.QueryEscape,
+	}
+}
@@ -0,0 +1,13 @@
+package envtmpl
+
+import "net/url"
+
+func init() {
+	funcMap["urlUnescape"] = &FuncStruct{
+		Short: "Unescapes a URL query string value.",
+		Examples: []string{
+			`{{ "Hello+World%%21" | %s }}`,
+		},
+		Fn: url.QueryUnescape,
+	}
+}
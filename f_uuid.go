@@ -0,0 +1,27 @@
+package envtmpl
+
+import (
+	"fmt"
+	"io"
+	"text/template"
+)
+
+func init() {
+	funcMap["uuid"] = &FuncFactoryStruct{
+		Short: "Create a random (v4) UUID.",
+		Examples: []string{
+			`{{ %s }}`,
+		},
+		Fn: func(_ *template.Template, rt *Runtime) interface{} {
+			return func() (string, error) {
+				var b [16]byte
+				if _, err := io.ReadFull(rt.Rand, b[:]); err != nil {
+					return "", err
+				}
+				b[6] = (b[6] & 0x0f) | 0x40 // Version 4.
+				b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant.
+				return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+			}
+		},
+	}
+}
@@ -1,16 +1,16 @@
-package main
+package envtmpl
 
 import "strings"
 
 func init() {
-	funcMap["wordWrap"] = &tmplFuncStruct{
-		short: "Wraps text to a given number of runes. Any existing white space is lost in the transformation.",
-		examples: []string{
+	funcMap["wordWrap"] = &FuncStruct{
+		Short: "Wraps text to a given number of runes. Any existing white space is lost in the transformation.",
+		Examples: []string{
 			`{{ "The quick brown fox jumps over the lazy dog." | %s 19 }}`,
 			`{{ "\t  The quick\nbrown fox jumps over the\n\t\tlazy dog." | %s 19 }}`,
 			`{{ "Γαζέες καὶ μυρτιὲς δὲν θὰ βρῶ πιὰ στὸ χρυσαφὶ ξέφωτο" | %s 19 }}`,
 		},
-		fn: func(n int, src string) string {
+		Fn: func(n int, src string) string {
 			var o []rune
 			var rl int
 			l := n
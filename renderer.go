@@ -0,0 +1,217 @@
+package envtmpl
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"text/template"
+)
+
+// Renderer parses and renders a set of associated templates with the
+// registered template functions available.
+type Renderer struct {
+	tmpl  *template.Template
+	funcs FuncMap
+	rt    *Runtime
+	data  interface{}
+	// sources are the sources parsed by ParseSources, without their text,
+	// keyed by name.
+	sources map[string]Source
+}
+
+// Option configures a Renderer.
+type Option func(*Renderer)
+
+// WithDelims sets the action delimiters used by subsequent parsing. Empty
+// delimiters mean the defaults, {{ and }}.
+func WithDelims(left, right string) Option {
+	return func(r *Renderer) {
+		r.tmpl.Delims(left, right)
+	}
+}
+
+// WithStrict makes rendering fail when a template references a map key,
+// such as an environment variable, that isn't set.
+func WithStrict() Option {
+	return func(r *Renderer) {
+		r.tmpl.Option("missingkey=error")
+	}
+}
+
+// WithData sets the data passed to templates when Render is given nil.
+func WithData(data interface{}) Option {
+	return func(r *Renderer) {
+		r.data = data
+	}
+}
+
+// WithFuncs makes additional functions available to this Renderer only,
+// taking precedence over registered functions with the same name.
+func WithFuncs(m FuncMap) Option {
+	return func(r *Renderer) {
+		for k, v := range m {
+			r.funcs[k] = v
+		}
+	}
+}
+
+// WithRuntime sets the Runtime used by template functions to access the
+// outside world. The DefaultRuntime is used otherwise.
+func WithRuntime(rt *Runtime) Option {
+	return func(r *Renderer) {
+		r.rt = rt
+	}
+}
+
+// New creates a Renderer. The name is used in error messages.
+func New(name string, opts ...Option) *Renderer {
+	r := &Renderer{
+		tmpl:    template.New(name),
+		funcs:   Funcs(),
+		rt:      DefaultRuntime(),
+		sources: make(map[string]Source),
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	r.tmpl.Funcs(r.funcs.TemplateFuncs(r.tmpl, r.rt))
+	return r
+}
+
+// Parse parses text as the template name.
+func (r *Renderer) Parse(name, text string) error {
+	t := r.tmpl
+	if name != t.Name() {
+		t = t.New(name)
+	}
+	_, err := t.Parse(text)
+	return err
+}
+
+// ParseGlob parses the files matching pattern. Each template is named after
+// the base name of its file.
+func (r *Renderer) ParseGlob(pattern string) error {
+	_, err := r.tmpl.ParseGlob(pattern)
+	return err
+}
+
+// Source is the text of a template to be parsed by ParseSources.
+type Source struct {
+	// Name is the name of the template and File, if set, where it came
+	// from for use in error messages.
+	Name string
+	File string
+	Text string
+	// Group, if set, is where the source came from, such as an include
+	// directory. Sources in different groups may not define the same
+	// template.
+	Group string
+	// LeftDelim and RightDelim, if set, are used instead of the delimiters
+	// given to WithDelims.
+	LeftDelim  string
+	RightDelim string
+}
+
+// ParseFiles parses the named files. Each template is named after the base
+// name of its file, and unlike ParseGlob, it is an error for two files to
+// share a base name. As with ParseGlob, a define or block action in a later
+// file replaces one in an earlier file, so that a file can override the
+// blocks of a base template.
+func (r *Renderer) ParseFiles(files ...string) error {
+	srcs := make([]Source, len(files))
+	for i, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		srcs[i] = Source{Name: filepath.Base(file), File: file, Text: string(b)}
+	}
+	return r.ParseSources(srcs...)
+}
+
+// ParseSources parses each of srcs. As with ParseFiles, it is an error for
+// two sources to share a name, and later definitions replace earlier ones,
+// unless they come from sources in different groups, which is an error.
+func (r *Renderer) ParseSources(srcs ...Source) error {
+	defined := make(map[string]string)
+	for _, t := range r.tmpl.Templates() {
+		if t.Tree != nil {
+			defined[t.Name()] = t.Tree.ParseName
+		}
+	}
+	sourceFile := func(src Source) string {
+		if src.File != "" {
+			return src.File
+		}
+		return src.Name
+	}
+	for _, src := range srcs {
+		file := sourceFile(src)
+		if p, ok := r.sources[src.Name]; ok {
+			return fmt.Errorf("template: %s: %q is already defined by %s", file, src.Name, sourceFile(p))
+		}
+		if _, ok := defined[src.Name]; ok {
+			return fmt.Errorf("template: %s: %q is already defined", file, src.Name)
+		}
+		t := r.tmpl
+		if src.Name != t.Name() {
+			t = t.New(src.Name)
+		}
+		if src.LeftDelim != "" || src.RightDelim != "" {
+			t.Delims(src.LeftDelim, src.RightDelim)
+		}
+		if _, err := t.Parse(src.Text); err != nil {
+			return err
+		}
+		for _, t := range r.tmpl.Templates() {
+			if t.Tree == nil || t.Tree.ParseName != src.Name {
+				continue
+			}
+			p, ok := r.sources[defined[t.Name()]]
+			if ok && p.Group != "" && src.Group != "" && p.Group != src.Group {
+				return fmt.Errorf("template: %s: %q is already defined by %s", file, t.Name(), sourceFile(p))
+			}
+			defined[t.Name()] = src.Name
+		}
+		src.Text = ""
+		r.sources[src.Name] = src
+	}
+	return nil
+}
+
+// Template returns the underlying template set.
+func (r *Renderer) Template() *template.Template {
+	return r.tmpl
+}
+
+// FuncMap returns the template functions bound to their own copy of the
+// template set, along with a function that releases any state they hold,
+// such as values stored with set. release must be called once the functions
+// are no longer used.
+func (r *Renderer) FuncMap() (funcs template.FuncMap, release func()) {
+	t, err := r.tmpl.Clone()
+	if err != nil {
+		t = template.New(r.tmpl.Name())
+	}
+	return r.funcs.TemplateFuncs(t, r.rt), func() { r.funcs.Release(t) }
+}
+
+// Render executes the template name, writing the output to w. The data given
+// to WithData is used when data is nil.
+//
+// Each call renders with its own copy of the template set, so state kept by
+// functions such as set and get is scoped to a single render. Render may be
+// called concurrently.
+func (r *Renderer) Render(w io.Writer, name string, data interface{}) error {
+	if data == nil {
+		data = r.data
+	}
+	t, err := r.tmpl.Clone()
+	if err != nil {
+		return err
+	}
+	t.Funcs(r.funcs.TemplateFuncs(t, r.rt))
+	defer r.funcs.Release(t)
+	return t.ExecuteTemplate(w, name, data)
+}
@@ -0,0 +1,82 @@
+package envtmpl
+
+import (
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Runtime is the outside world as seen by template functions. Functions
+// that read files, the time, random numbers or the environment should do
+// so through the Runtime they are given rather than directly, so that they
+// can be run against fixtures.
+type Runtime struct {
+	FS        FS
+	Now       func() time.Time
+	Rand      io.Reader
+	LookupEnv func(key string) (string, bool)
+}
+
+// FS is a read only file system. Unlike io/fs.FS, names are operating
+// system paths and may be absolute or contain "..".
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+}
+
+// OSFS is an FS backed by the operating system.
+type OSFS struct{}
+
+func (OSFS) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+// MapFS is an in-memory FS mapping names to file contents.
+type MapFS map[string]string
+
+func (m MapFS) ReadFile(name string) ([]byte, error) {
+	s, ok := m[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return []byte(s), nil
+}
+
+// DefaultRuntime returns a Runtime backed by the operating system.
+func DefaultRuntime() *Runtime {
+	return &Runtime{
+		FS:        OSFS{},
+		Now:       time.Now,
+		Rand:      rand.Reader,
+		LookupEnv: os.LookupEnv,
+	}
+}
+
+// ExampleRuntime returns a deterministic, in-memory Runtime. It is used to
+// render the function examples.
+func ExampleRuntime() *Runtime {
+	env := map[string]string{"FOO": "foo"}
+	return &Runtime{
+		FS:   MapFS{"../example/hello.txt": "Hello, 世界"},
+		Now:  func() time.Time { return time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC) },
+		Rand: &exampleRand{},
+		LookupEnv: func(k string) (string, bool) {
+			v, ok := env[k]
+			return v, ok
+		},
+	}
+}
+
+// exampleRand is a predictable random source, counting up from zero.
+type exampleRand struct {
+	n byte
+}
+
+func (r *exampleRand) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.n
+		r.n++
+	}
+	return len(p), nil
+}